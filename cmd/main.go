@@ -17,12 +17,18 @@ import (
 func logSnippets() {
 	cfg := proxy.GetConfig()
 	fmt.Println("\npa.js:")
-	fmt.Println(fmt.Sprintf(`<script defer type="text/javascript"
+
+	if cfg.Script.Rewrite {
+		fmt.Println(fmt.Sprintf(`<script defer type="text/javascript" src="%s"></script>`, filepath.Join(cfg.BasePath, cfg.JSFilename)))
+	} else {
+		fmt.Println(fmt.Sprintf(`<script defer type="text/javascript"
 	src="%s"
 	id="pianjs"
 	data-hit-endpoint="%s"
 	data-event-endpoint="%s"
 	data-session-endpoint="%s"></script>`, filepath.Join(cfg.BasePath, cfg.JSFilename), filepath.Join(cfg.BasePath, cfg.PageViewPath), filepath.Join(cfg.BasePath, cfg.EventPath), filepath.Join(cfg.BasePath, cfg.SessionPath)))
+	}
+
 	fmt.Println()
 }
 
@@ -36,6 +42,8 @@ func startServer(handler http.Handler) {
 		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
 	}
 
+	done := make(chan struct{})
+
 	go func() {
 		sigint := make(chan os.Signal, 1)
 		signal.Notify(sigint, os.Interrupt)
@@ -49,6 +57,7 @@ func startServer(handler http.Handler) {
 		}
 
 		cancel()
+		close(done)
 	}()
 
 	if cfg.Server.TLS {
@@ -64,11 +73,16 @@ func startServer(handler http.Handler) {
 			panic(err)
 		}
 	}
+
+	<-done
 }
 
 func main() {
 	proxy.LoadConfig()
+	proxy.SetupBots()
+	proxy.SetupIPFilter()
 	proxy.SetupClients()
 	logSnippets()
 	startServer(proxy.GetRouter())
+	proxy.StopClients()
 }
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ClientStatus is the health state of a client returned by the admin endpoint.
+type ClientStatus struct {
+	Name      string        `json:"name"`
+	Breaker   BreakerStatus `json:"circuit_breaker"`
+	QueueSize int64         `json:"queue_size"`
+	BotHits   uint64        `json:"bot_hits"`
+}
+
+// OriginStatus is the number of requests rejected by the origin check returned by the admin endpoint.
+type OriginStatus struct {
+	Rejected uint64 `json:"rejected"`
+}
+
+func serveAdmin(router *chi.Mux) {
+	router.Route(config.Admin.Path, func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/clients", adminClients)
+		r.Get("/origins", adminOrigins)
+	})
+}
+
+func adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.Admin.Token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func adminClients(w http.ResponseWriter, _ *http.Request) {
+	status := make([]ClientStatus, 0, len(clients))
+
+	for _, c := range clients {
+		s := ClientStatus{Name: c.name}
+
+		if c.breaker != nil {
+			s.Breaker = c.breaker.status()
+		}
+
+		if c.queue != nil {
+			s.QueueSize = c.queue.bytes()
+		}
+
+		if c.botHits != nil {
+			s.BotHits = c.botHits.Load()
+		}
+
+		status = append(status, s)
+	}
+
+	writeJSON(w, status)
+}
+
+func adminOrigins(w http.ResponseWriter, _ *http.Request) {
+	status := OriginStatus{}
+
+	if origins != nil {
+		status.Rejected = origins.rejected.Load()
+	}
+
+	writeJSON(w, status)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error sending response", "err", err)
+	}
+}
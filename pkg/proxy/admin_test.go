@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminClients(t *testing.T) {
+	mock, server := newAPIMock(t)
+	mock.setStatus(http.StatusInternalServerError)
+	c := newTestClient("test", server)
+	c.breaker = newBreaker("test", 1, time.Minute)
+	setTestClients(t, c)
+	config.Admin = Admin{Path: "/admin", Token: "token"}
+	assert.Error(t, c.send(&Hit{Kind: pageViewHit}))
+	assert.ErrorIs(t, c.send(&Hit{Kind: pageViewHit}), errCircuitOpen)
+	router := chi.NewRouter()
+	serveAdmin(router)
+	req := httptest.NewRequest(http.MethodGet, "/admin/clients", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	req.Header.Set("Authorization", "Bearer token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var status []ClientStatus
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.Len(t, status, 1)
+	assert.Equal(t, "test", status[0].Name)
+	assert.Equal(t, breakerOpen, status[0].Breaker.State)
+	assert.Equal(t, 1, status[0].Breaker.Failures)
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	errAPIIP        = errors.New("ip invalid")
+	errAPIUserAgent = errors.New("user agent missing")
+)
+
+// apiHitData is the JSON representation of a hit sent to the server-side API.
+// In contrast to the browser endpoints, the visitor data is passed explicitly instead of being read from the request.
+type apiHitData struct {
+	hitData
+	IP                     string `json:"ip"`
+	UserAgent              string `json:"user_agent"`
+	AcceptLanguage         string `json:"accept_language"`
+	SecCHUA                string `json:"sec_ch_ua"`
+	SecCHUAMobile          string `json:"sec_ch_ua_mobile"`
+	SecCHUAPlatform        string `json:"sec_ch_ua_platform"`
+	SecCHUAPlatformVersion string `json:"sec_ch_ua_platform_version"`
+	SecCHWidth             string `json:"sec_ch_width"`
+	SecCHViewportWidth     string `json:"sec_ch_viewport_width"`
+}
+
+// serveAPI sets up the page view, event, and session endpoints for server-side tracking.
+func serveAPI(router *chi.Mux) {
+	path := filepath.Join(config.BasePath, config.API.Path)
+	router.Post(filepath.Join(path, config.PageViewPath), apiHandler(pageViewHit))
+	router.Post(filepath.Join(path, config.EventPath), apiHandler(eventHit))
+	router.Post(filepath.Join(path, config.SessionPath), apiHandler(sessionHit))
+}
+
+// apiHandler authenticates the request using an API key and sends the hit to the clients the key is scoped to.
+func apiHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := getAPIKey(r)
+
+		if key == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, err := readBody(w, r)
+
+		if err != nil {
+			w.WriteHeader(getBodyErrorStatus(err))
+			return
+		}
+
+		var data apiHitData
+
+		if err := json.Unmarshal(body, &data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		hit, err := data.hit(kind)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if status := getResponseStatus(fanOutTo(hit, getClientsByName(key.Clients)), config.Policy); status != http.StatusOK {
+			w.WriteHeader(status)
+		}
+	}
+}
+
+// getAPIKey returns the API key for the bearer token or nil if it is invalid.
+func getAPIKey(r *http.Request) *APIKey {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if !found || token == "" {
+		return nil
+	}
+
+	for i := range config.API.Keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.API.Keys[i].Key)) == 1 {
+			return &config.API.Keys[i]
+		}
+	}
+
+	return nil
+}
+
+// hit validates the data and creates a hit from it.
+func (data *apiHitData) hit(kind string) (*Hit, error) {
+	if net.ParseIP(data.IP) == nil {
+		return nil, errAPIIP
+	}
+
+	if data.UserAgent == "" {
+		return nil, errAPIUserAgent
+	}
+
+	hit := &Hit{
+		Kind:       kind,
+		Time:       time.Now().UTC(),
+		ServerSide: true,
+	}
+	data.apply(hit)
+	hit.Options.IP = data.IP
+	hit.Options.UserAgent = data.UserAgent
+	hit.Options.AcceptLanguage = data.AcceptLanguage
+	hit.Options.SecCHUA = data.SecCHUA
+	hit.Options.SecCHUAMobile = data.SecCHUAMobile
+	hit.Options.SecCHUAPlatform = data.SecCHUAPlatform
+	hit.Options.SecCHUAPlatformVersion = data.SecCHUAPlatformVersion
+	hit.Options.SecCHWidth = data.SecCHWidth
+	hit.Options.SecCHViewportWidth = data.SecCHViewportWidth
+
+	if err := hit.validate(); err != nil {
+		return nil, err
+	}
+
+	return hit, nil
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPI(t *testing.T) {
+	mock, server := newAPIMock(t)
+	otherMock, otherServer := newAPIMock(t)
+	setTestClients(t, newTestClient("blog", server), newTestClient("shop", otherServer))
+	config.API.Keys = []APIKey{
+		{Name: "checkout", Key: "checkout-key", Clients: []string{"shop"}},
+		{Name: "gateway", Key: "gateway-key"},
+	}
+	body := `{
+		"url": "https://example.com/checkout",
+		"event_name": "Purchase",
+		"event_meta": {"amount": "42"},
+		"ip": "203.0.113.7",
+		"user_agent": "Mozilla/5.0",
+		"accept_language": "de-DE",
+		"sec_ch_ua_platform": "Linux"
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/p/api/e", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer checkout-key")
+	req.Header.Set("User-Agent", "Go-http-client/1.1")
+	w := httptest.NewRecorder()
+	apiHandler(eventHit)(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, mock.received())
+	hits := otherMock.received()
+	assert.Len(t, hits, 1)
+	assert.Equal(t, "Purchase", hits[0].Name)
+	assert.Equal(t, map[string]string{"amount": "42"}, hits[0].Metadata)
+	assert.Equal(t, "203.0.113.7", hits[0].IP)
+	assert.Equal(t, "Mozilla/5.0", hits[0].UserAgent)
+	assert.Equal(t, "de-DE", hits[0].AcceptLanguage)
+	assert.Equal(t, "Linux", hits[0].SecCHUAPlatform)
+	req = httptest.NewRequest(http.MethodPost, "/p/api/pv", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer gateway-key")
+	w = httptest.NewRecorder()
+	apiHandler(pageViewHit)(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, mock.received(), 1)
+	assert.Len(t, otherMock.received(), 2)
+}
+
+func TestAPIInvalid(t *testing.T) {
+	_, server := newAPIMock(t)
+	setTestClients(t, newTestClient("blog", server))
+	config.API.Keys = []APIKey{{Name: "gateway", Key: "gateway-key"}}
+
+	for _, token := range []string{"", "Bearer ", "Bearer invalid", "gateway-key"} {
+		req := httptest.NewRequest(http.MethodPost, "/p/api/pv", nil)
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		apiHandler(pageViewHit)(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	for _, body := range []string{
+		`{`,
+		`{"url": "https://example.com", "user_agent": "Mozilla/5.0"}`,
+		`{"url": "https://example.com", "ip": "203.0.113.7"}`,
+		`{"url": "/relative", "ip": "203.0.113.7", "user_agent": "Mozilla/5.0"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/p/api/pv", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer gateway-key")
+		w := httptest.NewRecorder()
+		apiHandler(pageViewHit)(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var (
+	errBatchType       = errors.New("type invalid")
+	errBatchTimeOffset = errors.New("time offset not supported")
+)
+
+// batchItem is a single page view, event, or session extension sent to the batch endpoint.
+// Items setting a time offset are rejected, as Pirsch does not accept timestamps and the hit would be recorded at the time it is delivered.
+type batchItem struct {
+	hitData
+	Type       string `json:"type"`
+	TimeOffset *int   `json:"time_offset"`
+}
+
+// batchResult is the result for a single batch item.
+// The status is the one the corresponding single-hit endpoint would have responded with.
+type batchResult struct {
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batch accepts multiple hits at once and returns a result for each of them in the same order.
+// Invalid items are rejected individually without affecting the others.
+// Valid items are delivered in order, so that page views are sent before the events following them.
+func batch(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(w, r)
+
+	if err != nil {
+		w.WriteHeader(getBodyErrorStatus(err))
+		return
+	}
+
+	var items []batchItem
+
+	if err := json.Unmarshal(body, &items); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if len(items) == 0 || len(items) > config.Batch.MaxItems {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	results := make([]batchResult, len(items))
+
+	for i, item := range items {
+		hit, err := item.hit(r)
+
+		if errors.Is(err, errToken) {
+			results[i] = batchResult{Status: http.StatusForbidden, Error: err.Error()}
+			continue
+		} else if err != nil {
+			results[i] = batchResult{Status: http.StatusBadRequest, Error: err.Error()}
+			continue
+		}
+
+		results[i] = batchResult{Status: getResponseStatus(fanOut(hit), config.Policy)}
+	}
+
+	writeJSON(w, results)
+}
+
+// hit validates the item and creates a hit from it.
+func (item *batchItem) hit(r *http.Request) (*Hit, error) {
+	if item.Type != pageViewHit && item.Type != eventHit && item.Type != sessionHit {
+		return nil, errBatchType
+	}
+
+	if item.TimeOffset != nil {
+		return nil, errBatchTimeOffset
+	}
+
+	hit := newHit(item.Type, r)
+	item.apply(hit)
+
+	if err := hit.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := verifyToken(hit, getToken(r, item.Token)); err != nil {
+		return nil, err
+	}
+
+	return hit, nil
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server, NewHostnameFilter([]string{"example.com"})))
+	config.Batch = Batch{MaxItems: 10}
+	req := httptest.NewRequest(http.MethodPost, "/p/b", strings.NewReader(`[
+		{"type": "page_view", "url": "https://example.com/foo", "title": "Foo", "referrer": "https://google.com"},
+		{"type": "event", "url": "https://example.com/foo", "event_name": "Signup"},
+		{"type": "session", "url": "https://example.com/bar"},
+		{"type": "page_view", "url": "https://filtered.com/"},
+		{"type": "unknown", "url": "https://example.com/"},
+		{"type": "page_view", "url": "/relative"},
+		{"type": "event", "url": "https://example.com/"},
+		{"type": "page_view", "url": "https://example.com/", "time_offset": 0},
+		{"type": "page_view", "url": "https://example.com/", "time_offset": 30}
+	]`))
+	req.Header.Set("User-Agent", "ua")
+	w := httptest.NewRecorder()
+	batch(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var results []batchResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	assert.Equal(t, []batchResult{
+		{Status: http.StatusOK},
+		{Status: http.StatusOK},
+		{Status: http.StatusOK},
+		{Status: http.StatusOK},
+		{Status: http.StatusBadRequest, Error: errBatchType.Error()},
+		{Status: http.StatusBadRequest, Error: errHitURL.Error()},
+		{Status: http.StatusBadRequest, Error: errHitEventName.Error()},
+		{Status: http.StatusBadRequest, Error: errBatchTimeOffset.Error()},
+		{Status: http.StatusBadRequest, Error: errBatchTimeOffset.Error()},
+	}, results)
+	hits := mock.received()
+	assert.Len(t, hits, 3)
+
+	for _, hit := range hits {
+		assert.Equal(t, "ua", hit.UserAgent)
+	}
+
+	// delivered in order
+	assert.Equal(t, "https://example.com/foo", hits[0].URL)
+	assert.Equal(t, "Foo", hits[0].Title)
+	assert.Empty(t, hits[0].Name)
+	assert.Equal(t, "https://example.com/foo", hits[1].URL)
+	assert.Equal(t, "Signup", hits[1].Name)
+	assert.Equal(t, "https://example.com/bar", hits[2].URL)
+}
+
+func TestBatchInvalid(t *testing.T) {
+	setTestClients(t)
+	config.Batch = Batch{MaxItems: 1}
+
+	for _, body := range []string{"", "{}", "[]", `[{"type": "page_view"}, {"type": "page_view"}]`} {
+		req := httptest.NewRequest(http.MethodPost, "/p/b", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		batch(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	metaParamPrefix = "meta_"
+)
+
+var (
+	errUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// readBody reads the request body up to the configured maximum size.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	return io.ReadAll(http.MaxBytesReader(w, r.Body, int64(config.Server.MaxBodySize)*1024))
+}
+
+// readHitData reads the hit data from the request body depending on the content type.
+// JSON can be sent as application/json or text/plain (like navigator.sendBeacon does for strings).
+// Form bodies (like navigator.sendBeacon does for URLSearchParams and FormData) use the same field names,
+// except for the event metadata and tags, which are passed as "meta_<key>" and "tag_<key>" fields.
+// It returns nil if the body is empty.
+func readHitData(w http.ResponseWriter, r *http.Request) (*hitData, error) {
+	body, err := readBody(w, r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	contentType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if err != nil {
+		// assume JSON for backwards compatibility if no content type is set
+		contentType = "application/json"
+	}
+
+	var values url.Values
+
+	switch contentType {
+	case "application/json", "text/plain":
+		data := new(hitData)
+
+		if err := json.Unmarshal(body, data); err != nil {
+			return nil, err
+		}
+
+		return data, nil
+	case "application/x-www-form-urlencoded":
+		values, err = url.ParseQuery(string(body))
+
+		if err != nil {
+			return nil, err
+		}
+	case "multipart/form-data":
+		form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(int64(len(body)))
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() { _ = form.RemoveAll() }()
+		values = form.Value
+	default:
+		return nil, errUnsupportedMediaType
+	}
+
+	return getFormHitData(values), nil
+}
+
+func getFormHitData(values url.Values) *hitData {
+	width, _ := strconv.Atoi(values.Get("screen_width"))
+	height, _ := strconv.Atoi(values.Get("screen_height"))
+	duration, _ := strconv.Atoi(values.Get("event_duration"))
+	return &hitData{
+		Code:          values.Get("identification_code"),
+		URL:           values.Get("url"),
+		Title:         values.Get("title"),
+		Referrer:      values.Get("referrer"),
+		ScreenWidth:   width,
+		ScreenHeight:  height,
+		EventName:     values.Get("event_name"),
+		EventDuration: duration,
+		EventMeta:     getPrefixedParams(values, metaParamPrefix),
+		Tags:          getTags(values),
+		Token:         values.Get("token"),
+	}
+}
+
+// formatValue returns a JSON value as a string.
+// Strings are returned as is, numbers and booleans are formatted, and everything else is encoded as JSON.
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// getBodyErrorStatus returns the response status for an error reading the request body.
+func getBodyErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	} else if errors.Is(err, errUnsupportedMediaType) {
+		return http.StatusUnsupportedMediaType
+	}
+
+	return http.StatusBadRequest
+}
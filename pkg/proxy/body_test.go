@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadHitData(t *testing.T) {
+	setTestClients(t)
+	expected := &hitData{
+		URL:         "https://example.com/foo",
+		ScreenWidth: 1920,
+		EventName:   "Signup",
+		EventMeta:   map[string]string{"plan": "pro"},
+		Tags:        map[string]string{"author": "John"},
+	}
+	jsonBody := `{"url": "https://example.com/foo", "screen_width": 1920, "event_name": "Signup", "event_meta": {"plan": "pro"}, "tags": {"author": "John"}}`
+	formBody := "url=https%3A%2F%2Fexample.com%2Ffoo&screen_width=1920&event_name=Signup&meta_plan=pro&tag_author=John"
+
+	for contentType, body := range map[string]string{
+		"":                                  jsonBody,
+		"application/json":                  jsonBody,
+		"text/plain;charset=UTF-8":          jsonBody,
+		"application/x-www-form-urlencoded": formBody,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/p/e", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		data, err := readHitData(httptest.NewRecorder(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, data)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	assert.NoError(t, form.WriteField("url", "https://example.com/foo"))
+	assert.NoError(t, form.WriteField("screen_width", "1920"))
+	assert.NoError(t, form.WriteField("event_name", "Signup"))
+	assert.NoError(t, form.WriteField("meta_plan", "pro"))
+	assert.NoError(t, form.WriteField("tag_author", "John"))
+	assert.NoError(t, form.Close())
+	req := httptest.NewRequest(http.MethodPost, "/p/e", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	data, err := readHitData(httptest.NewRecorder(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, data)
+	req = httptest.NewRequest(http.MethodPost, "/p/s", nil)
+	data, err = readHitData(httptest.NewRecorder(), req)
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestReadHitDataError(t *testing.T) {
+	setTestClients(t)
+	config.Server.MaxBodySize = 1
+	req := httptest.NewRequest(http.MethodPost, "/p/e", strings.NewReader(`{"url": "`+strings.Repeat("a", 1024)+`"}`))
+	_, err := readHitData(httptest.NewRecorder(), req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, getBodyErrorStatus(err))
+	req = httptest.NewRequest(http.MethodPost, "/p/e", strings.NewReader("<xml/>"))
+	req.Header.Set("Content-Type", "application/xml")
+	_, err = readHitData(httptest.NewRecorder(), req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, getBodyErrorStatus(err))
+	req = httptest.NewRequest(http.MethodPost, "/p/e", strings.NewReader("{"))
+	_, err = readHitData(httptest.NewRecorder(), req)
+	assert.Equal(t, http.StatusBadRequest, getBodyErrorStatus(err))
+}
+
+func TestEventBeacon(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	req := httptest.NewRequest(http.MethodPost, "/p/e", strings.NewReader(`{"url": "https://example.com/foo", "event_name": "Signup"}`))
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	w := httptest.NewRecorder()
+	event(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	req = httptest.NewRequest(http.MethodPost, "/p/s", strings.NewReader("url=https%3A%2F%2Fexample.com%2Fbar"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	session(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	hits := mock.received()
+	assert.Len(t, hits, 2)
+	assert.Equal(t, "Signup", hits[0].Name)
+	assert.Equal(t, "https://example.com/bar", hits[1].URL)
+}
+
+func TestFormatValue(t *testing.T) {
+	assert.Equal(t, "text", formatValue("text"))
+	assert.Equal(t, "3", formatValue(float64(3)))
+	assert.Equal(t, "1.5", formatValue(1.5))
+	assert.Equal(t, "false", formatValue(false))
+	assert.Equal(t, `["x"]`, formatValue([]any{"x"}))
+	assert.Empty(t, formatValue(nil))
+}
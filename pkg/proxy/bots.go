@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	botReasonUserAgent      = "user_agent"
+	botReasonMissingUA      = "missing_user_agent"
+	botReasonMissingLang    = "missing_accept_language"
+	botReasonMissingHints   = "missing_client_hints"
+	defaultBotReloadSeconds = 60
+)
+
+var (
+	bots *botFilter
+
+	// embeddedBotPatterns are used if no pattern file is configured.
+	//go:embed static/bots.txt
+	embeddedBotPatterns []byte
+)
+
+// botFilter detects bots and crawlers by their User-Agent and missing headers regular browsers send.
+type botFilter struct {
+	patterns       []string
+	regex          []*regexp.Regexp
+	missingHeaders bool
+	m              sync.RWMutex
+}
+
+// SetupBots initializes the bot filter if enabled for any client.
+// The patterns are loaded from the configured file and reloaded when it changes.
+func SetupBots() {
+	if !botsEnabled() {
+		return
+	}
+
+	bots = &botFilter{missingHeaders: config.Bots.MissingHeaders}
+
+	if config.Bots.File == "" {
+		if err := bots.load(embeddedBotPatterns); err != nil {
+			slog.Error("Error loading embedded bot patterns", "err", err)
+			panic(err)
+		}
+
+		return
+	}
+
+	if err := watchFile(config.Bots.File, time.Duration(config.Bots.ReloadInterval)*time.Second, bots.load); err != nil {
+		slog.Error("Error loading bot patterns", "err", err, "path", config.Bots.File)
+		panic(err)
+	}
+}
+
+func botsEnabled() bool {
+	if config.Bots.Enabled {
+		return true
+	}
+
+	for _, c := range config.Clients {
+		if c.FilterBots != nil && *c.FilterBots {
+			return true
+		}
+	}
+
+	return false
+}
+
+// load parses the patterns, one per line.
+// Patterns are matched as case-insensitive substrings or as case-insensitive regular expressions using the "regex:" prefix.
+// Empty lines and lines starting with # are ignored.
+func (f *botFilter) load(data []byte) error {
+	patterns := make([]string, 0)
+	regex := make([]*regexp.Regexp, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if pattern, found := strings.CutPrefix(line, "regex:"); found {
+			r, err := regexp.Compile("(?i)" + pattern)
+
+			if err != nil {
+				return fmt.Errorf("error compiling bot pattern %s: %w", line, err)
+			}
+
+			regex = append(regex, r)
+		} else {
+			patterns = append(patterns, strings.ToLower(line))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.patterns = patterns
+	f.regex = regex
+	return nil
+}
+
+// detect returns the reason if the hit was sent by a bot or an empty string otherwise.
+func (f *botFilter) detect(hit *Hit) string {
+	ua := hit.Options.UserAgent
+
+	if strings.TrimSpace(ua) == "" {
+		return botReasonMissingUA
+	}
+
+	lower := strings.ToLower(ua)
+	f.m.RLock()
+
+	for _, pattern := range f.patterns {
+		if strings.Contains(lower, pattern) {
+			f.m.RUnlock()
+			return botReasonUserAgent
+		}
+	}
+
+	for _, r := range f.regex {
+		if r.MatchString(ua) {
+			f.m.RUnlock()
+			return botReasonUserAgent
+		}
+	}
+
+	f.m.RUnlock()
+
+	// the headers are optional for server-side hits
+	if f.missingHeaders && !hit.ServerSide {
+		if hit.Options.AcceptLanguage == "" {
+			return botReasonMissingLang
+		}
+
+		// Chromium based browsers always send the low entropy client hints (in secure contexts)
+		if strings.Contains(ua, "Chrome/") && hit.Options.SecCHUA == "" {
+			return botReasonMissingHints
+		}
+	}
+
+	return ""
+}
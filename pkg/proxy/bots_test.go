@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	pirsch "github.com/pirsch-analytics/pirsch-go-sdk/v2/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testChromeUA  = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+	testFirefoxUA = "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"
+)
+
+func TestBotFilterLoad(t *testing.T) {
+	f := new(botFilter)
+	assert.NoError(t, f.load([]byte("# comment\n\nGooglebot\nregex:^curl/\\d+\n")))
+	assert.Equal(t, []string{"googlebot"}, f.patterns)
+	assert.Len(t, f.regex, 1)
+	assert.Error(t, f.load([]byte("regex:(")))
+	assert.Equal(t, []string{"googlebot"}, f.patterns)
+}
+
+func TestBotFilterDetect(t *testing.T) {
+	f := new(botFilter)
+	assert.NoError(t, f.load([]byte("bot/\nheadless\nregex:^curl/")))
+	hit := func(ua, lang, hints string) *Hit {
+		return &Hit{Options: pirsch.PageViewOptions{UserAgent: ua, AcceptLanguage: lang, SecCHUA: hints}}
+	}
+	assert.Equal(t, botReasonMissingUA, f.detect(hit("", "en", "")))
+	assert.Equal(t, botReasonUserAgent, f.detect(hit("Mozilla/5.0 (compatible; Googlebot/2.1)", "en", "")))
+	assert.Equal(t, botReasonUserAgent, f.detect(hit("Mozilla/5.0 HeadlessChrome/124.0.0.0", "en", "")))
+	assert.Equal(t, botReasonUserAgent, f.detect(hit("curl/8.0.1", "en", "")))
+	assert.Equal(t, botReasonUserAgent, f.detect(hit("CURL/8.0.1", "en", "")))
+	assert.Empty(t, f.detect(hit(testChromeUA, "", "")))
+	assert.Empty(t, f.detect(hit(testFirefoxUA, "en", "")))
+	f.missingHeaders = true
+	assert.Equal(t, botReasonMissingLang, f.detect(hit(testFirefoxUA, "", "")))
+	assert.Equal(t, botReasonMissingHints, f.detect(hit(testChromeUA, "en", "")))
+	assert.Empty(t, f.detect(hit(testChromeUA, "en", `"Chromium";v="124"`)))
+	assert.Empty(t, f.detect(hit(testFirefoxUA, "en", "")))
+	serverSide := hit(testChromeUA, "", "")
+	serverSide.ServerSide = true
+	assert.Empty(t, f.detect(serverSide))
+}
+
+func TestEmbeddedBotPatterns(t *testing.T) {
+	f := new(botFilter)
+	assert.NoError(t, f.load(embeddedBotPatterns))
+	hit := func(ua string) *Hit {
+		return &Hit{Options: pirsch.PageViewOptions{UserAgent: ua}}
+	}
+
+	for _, ua := range []string{
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		"Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)",
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)",
+		"TelegramBot (like TwitterBot)",
+		"Mozilla/5.0 (compatible; YandexBot/3.0)",
+		"Go-http-client/1.1",
+	} {
+		assert.Equal(t, botReasonUserAgent, f.detect(hit(ua)), ua)
+	}
+
+	for _, ua := range []string{
+		testChromeUA,
+		testFirefoxUA,
+		"Mozilla/5.0 (Linux; Android 10; CUBOT X30 Build/QP1A.190711.020) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 13; KINGKONG 9 Build/TP1A.220624.014; CUBOT) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+	} {
+		assert.Empty(t, f.detect(hit(ua)), ua)
+	}
+}
+
+func TestPageViewBots(t *testing.T) {
+	mock, server := newAPIMock(t)
+	otherMock, otherServer := newAPIMock(t)
+	filtered := newTestClient("filtered", server)
+	filtered.filterBots = true
+	filtered.botHits = new(atomic.Uint64)
+	setTestClients(t, filtered, newTestClient("unfiltered", otherServer))
+	bots = new(botFilter)
+	assert.NoError(t, bots.load(embeddedBotPatterns))
+	t.Cleanup(func() {
+		bots = nil
+	})
+
+	for _, ua := range []string{testFirefoxUA, "Mozilla/5.0 (compatible; bingbot/2.0)", "Go-http-client/1.1"} {
+		req := httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo", nil)
+		req.Header.Set("User-Agent", ua)
+		w := httptest.NewRecorder()
+		pageView(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	assert.Len(t, mock.received(), 1)
+	assert.Equal(t, testFirefoxUA, mock.received()[0].UserAgent)
+	assert.Len(t, otherMock.received(), 3)
+	assert.Equal(t, uint64(2), filtered.botHits.Load())
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+var (
+	errCircuitOpen = errors.New("circuit breaker is open")
+)
+
+// breaker is a circuit breaker for a client.
+// It opens after a number of consecutive failures and rejects requests until the timeout has passed.
+// A single probe request is then let through (half-open) to decide whether to close it again.
+type breaker struct {
+	client    string
+	threshold int
+	timeout   time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	m         sync.Mutex
+}
+
+// BreakerStatus is the current state of a client circuit breaker.
+type BreakerStatus struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at,omitempty"`
+}
+
+func newBreaker(client string, threshold int, timeout time.Duration) *breaker {
+	return &breaker{
+		client:    client,
+		threshold: threshold,
+		timeout:   timeout,
+		state:     breakerClosed,
+	}
+}
+
+// allow returns whether a request can be sent.
+func (b *breaker) allow() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.timeout {
+			return false
+		}
+
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *breaker) success() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.failures = 0
+	b.probing = false
+
+	if b.state != breakerClosed {
+		b.setState(breakerClosed)
+	}
+}
+
+func (b *breaker) failure() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.failures++
+	b.probing = false
+
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *breaker) status() BreakerStatus {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return BreakerStatus{
+		State:    b.state,
+		Failures: b.failures,
+		OpenedAt: b.openedAt,
+	}
+}
+
+func (b *breaker) setState(state string) {
+	if state == breakerOpen {
+		slog.Warn("Circuit breaker opened", "client", b.client, "failures", b.failures, "timeout", b.timeout)
+	} else {
+		slog.Info("Circuit breaker state changed", "client", b.client, "state", state)
+	}
+
+	b.state = state
+}
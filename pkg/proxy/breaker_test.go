@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker("test", 2, time.Millisecond*20)
+	assert.True(t, b.allow())
+	b.failure()
+	assert.Equal(t, breakerClosed, b.status().State)
+	assert.True(t, b.allow())
+	b.failure()
+	assert.Equal(t, breakerOpen, b.status().State)
+	assert.False(t, b.allow())
+
+	// a single probe is let through after the timeout
+	time.Sleep(time.Millisecond * 25)
+	assert.True(t, b.allow())
+	assert.Equal(t, breakerHalfOpen, b.status().State)
+	assert.False(t, b.allow())
+	b.failure()
+	assert.Equal(t, breakerOpen, b.status().State)
+	assert.False(t, b.allow())
+	time.Sleep(time.Millisecond * 25)
+	assert.True(t, b.allow())
+	b.success()
+	assert.Equal(t, breakerClosed, b.status().State)
+	assert.Equal(t, 0, b.status().Failures)
+	assert.True(t, b.allow())
+	assert.True(t, b.allow())
+}
@@ -1,24 +1,65 @@
 package proxy
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"log/slog"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	pirsch "github.com/pirsch-analytics/pirsch-go-sdk/v2/pkg"
 )
 
+const (
+	maxRetryInterval = time.Minute * 5
+
+	policyAll = "all"
+	policyAny = "any"
+)
+
 var (
 	clients []client
+	workers sync.WaitGroup
+
+	// background tracks hits delivered after the response has been sent, so that StopClients can wait for them
+	background sync.WaitGroup
+
+	statusCodeRegex = regexp.MustCompile(`received status code (\d{3}) on request`)
 )
 
+type deliveryResult struct {
+	client string
+	queued bool
+	err    error
+}
+
 type client struct {
-	api    *pirsch.Client
-	filter []FilterFunc
+	name     string
+	api      *pirsch.Client
+	filter   []FilterFunc
+	hostname FilterFunc
+	ipFilter *ipFilter
+	// filterBots drops hits detected as bots and counts them
+	filterBots bool
+	botHits    *atomic.Uint64
+	tags       ClientTags
+	breaker    *breaker
+	queue      *queue
+	done       chan struct{}
 }
 
 // SetupClients initializes all configured clients.
 func SetupClients() {
 	for _, c := range config.Clients {
-		slog.Info("Adding client", "id", c.ID, "base_url", config.BaseURL)
+		name := getClientName(c)
+		slog.Info("Adding client", "name", name, "id", c.ID, "base_url", config.BaseURL)
 		pirschClient := pirsch.NewClient(c.ID, c.Secret, &pirsch.ClientConfig{
 			BaseURL: config.BaseURL,
 		})
@@ -30,11 +71,337 @@ func SetupClients() {
 			}
 		}
 
-		clients = append(clients, client{
-			api:    pirschClient,
-			filter: createFilter(c.Filter),
-		})
+		cl := client{
+			name:       name,
+			api:        pirschClient,
+			filter:     createFilter(c.Filter),
+			tags:       c.Tags,
+			breaker:    newBreaker(name, config.Breaker.Threshold, time.Duration(config.Breaker.Timeout)*time.Second),
+			filterBots: config.Bots.Enabled,
+			botHits:    new(atomic.Uint64),
+			ipFilter:   newIPFilter(c.IPFilter),
+		}
+
+		if c.FilterBots != nil {
+			cl.filterBots = *c.FilterBots
+		}
+
+		if len(c.Filter.Hostname) > 0 {
+			cl.hostname = NewHostnameFilter(c.Filter.Hostname)
+		}
+
+		if config.Queue.Path != "" {
+			q, err := newQueue(filepath.Join(config.Queue.Path, name),
+				int64(config.Queue.MaxSize)*1024*1024,
+				int64(config.Queue.SegmentSize)*1024*1024)
+
+			if err != nil {
+				slog.Error("Error opening queue", "err", err, "client", name)
+				panic(err)
+			}
+
+			cl.queue = q
+			cl.done = make(chan struct{})
+			workers.Add(1)
+			go cl.drain(time.Duration(config.Queue.MaxAge)*time.Hour, time.Duration(config.Queue.RetryInterval)*time.Second)
+		}
+
+		clients = append(clients, cl)
+	}
+}
+
+// StopClients waits for hits delivered in the background, stops delivering queued hits, closes the queues,
+// and stops watching the bot pattern and IP list files.
+// It must be called after the server has been shut down.
+func StopClients() {
+	background.Wait()
+	stopWatchers()
+
+	for _, c := range clients {
+		if c.done != nil {
+			close(c.done)
+		}
 	}
+
+	workers.Wait()
+
+	for _, c := range clients {
+		if c.queue != nil {
+			if err := c.queue.close(); err != nil {
+				slog.Error("Error closing queue", "err", err, "client", c.name)
+			}
+		}
+	}
+}
+
+// fanOut delivers the hit to all clients accepting it concurrently.
+// Each client is handled independently, so that a failing client does not affect the others.
+func fanOut(hit *Hit) []deliveryResult {
+	return fanOutTo(hit, clients)
+}
+
+// fanOutBackground delivers the hit like fanOut without waiting for the result.
+func fanOutBackground(hit *Hit) {
+	background.Add(1)
+
+	go func() {
+		defer background.Done()
+		fanOut(hit)
+	}()
+}
+
+// getClientsByName returns the clients for the given names.
+// All clients are returned if the list is empty.
+func getClientsByName(names []string) []client {
+	if len(names) == 0 {
+		return clients
+	}
+
+	selected := make([]client, 0, len(names))
+
+	for _, c := range clients {
+		for _, name := range names {
+			if c.name == name {
+				selected = append(selected, c)
+				break
+			}
+		}
+	}
+
+	return selected
+}
+
+// getClientsByDomain returns the clients whose hostname filter matches any of the domains.
+// Clients without a hostname filter accept all domains.
+func getClientsByDomain(domains []string) []client {
+	selected := make([]client, 0, len(clients))
+
+	for _, c := range clients {
+		if c.hostname == nil {
+			selected = append(selected, c)
+			continue
+		}
+
+		for _, domain := range domains {
+			if c.hostname(&Hit{Options: pirsch.PageViewOptions{URL: "https://" + domain}}) {
+				selected = append(selected, c)
+				break
+			}
+		}
+	}
+
+	return selected
+}
+
+// fanOutTo delivers the hit to the given clients accepting it concurrently.
+// Hits from blocked IPs and hits detected as bots are dropped for clients filtering bots.
+func fanOutTo(hit *Hit, clients []client) []deliveryResult {
+	if globalIPFilter != nil && !globalIPFilter.accept(hit.Options.IP) {
+		slog.Debug("Dropping hit from blocked IP", "kind", hit.Kind)
+		return nil
+	}
+
+	accepted := make([]client, 0, len(clients))
+	botReason, botChecked := "", false
+
+	for _, c := range clients {
+		if !acceptRequest(c, hit) {
+			continue
+		}
+
+		if c.ipFilter != nil && !c.ipFilter.accept(hit.Options.IP) {
+			slog.Debug("Dropping hit from blocked IP", "client", c.name, "kind", hit.Kind)
+			continue
+		}
+
+		if c.filterBots && bots != nil {
+			if !botChecked {
+				botReason, botChecked = bots.detect(hit), true
+			}
+
+			if botReason != "" {
+				c.botHits.Add(1)
+				slog.Debug("Dropping bot hit", "client", c.name, "kind", hit.Kind, "reason", botReason, "user_agent", hit.Options.UserAgent)
+				continue
+			}
+		}
+
+		accepted = append(accepted, c)
+	}
+
+	results := make([]deliveryResult, len(accepted))
+	var wg sync.WaitGroup
+
+	for i, c := range accepted {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			queued, err := c.deliver(hit)
+			results[i] = deliveryResult{client: c.name, queued: queued, err: err}
+
+			if errors.Is(err, errCircuitOpen) {
+				slog.Debug("Hit rejected by circuit breaker", "client", c.name, "kind", hit.Kind)
+			} else if err != nil {
+				slog.Error("Error delivering hit", "err", err, "client", c.name, "kind", hit.Kind)
+			} else {
+				slog.Debug("Hit delivered", "client", c.name, "kind", hit.Kind, "queued", queued)
+			}
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+// deliver adds the hit to the queue or sends it right away if the queue is disabled.
+func (c client) deliver(hit *Hit) (bool, error) {
+	if len(hit.Options.Tags) > 0 && (len(c.tags.Allowed) > 0 || c.tags.MaxLength > 0) {
+		h := *hit
+		h.Options.Tags = filterTags(hit.Options.Tags, c.tags)
+		hit = &h
+	}
+
+	if c.queue != nil {
+		return true, c.queue.push(hit)
+	}
+
+	return false, c.send(hit)
+}
+
+// send sends the hit to Pirsch.
+// If the circuit breaker is open, the hit is rejected without sending a request.
+// Any other response closes the breaker again, as Pirsch is reachable.
+func (c client) send(hit *Hit) error {
+	if c.breaker != nil && !c.breaker.allow() {
+		return errCircuitOpen
+	}
+
+	r := hit.request()
+	var err error
+
+	switch hit.Kind {
+	case eventHit:
+		err = c.api.Event(hit.EventName, hit.EventDuration, hit.EventMeta, r, &hit.Options)
+	case sessionHit:
+		err = c.api.Session(r, &hit.Options)
+	default:
+		err = c.api.PageView(r, &hit.Options)
+	}
+
+	if c.breaker != nil {
+		if isBreakerFailure(err) {
+			c.breaker.failure()
+		} else {
+			c.breaker.success()
+		}
+	}
+
+	return err
+}
+
+func (c client) drain(maxAge, retryInterval time.Duration) {
+	defer workers.Done()
+	wait := retryInterval
+
+	for {
+		hit, err := c.queue.peek()
+
+		if err != nil {
+			slog.Error("Error reading queue", "err", err, "client", c.name)
+		}
+
+		if hit == nil {
+			select {
+			case <-c.done:
+				return
+			case <-c.queue.notify:
+			case <-time.After(retryInterval):
+			}
+
+			continue
+		}
+
+		if time.Since(hit.Time) > maxAge {
+			slog.Warn("Dropping expired hit", "client", c.name, "kind", hit.Kind, "time", hit.Time)
+			c.queue.pop()
+			continue
+		}
+
+		if err := c.send(hit); err != nil {
+			if isPermanentError(err) {
+				slog.Error("Dropping queued hit rejected by Pirsch", "err", err, "client", c.name, "kind", hit.Kind)
+				c.queue.pop()
+				continue
+			}
+
+			if !errors.Is(err, errCircuitOpen) {
+				slog.Error("Error delivering queued hit", "err", err, "client", c.name, "kind", hit.Kind, "retry_in", wait)
+			}
+
+			select {
+			case <-c.done:
+				return
+			case <-time.After(wait):
+			}
+
+			if !errors.Is(err, errCircuitOpen) {
+				wait = min(wait*2, maxRetryInterval)
+			}
+
+			continue
+		}
+
+		wait = retryInterval
+		c.queue.pop()
+	}
+}
+
+// getStatusCode returns the status code of a response rejected by Pirsch.
+// Zero is returned for network errors and failed token refreshes.
+// The SDK neither returns a typed error nor accepts a custom HTTP client, so the code is parsed from the error message.
+// TestGetStatusCode makes sure this still works for the vendored SDK version.
+func getStatusCode(err error) int {
+	match := statusCodeRegex.FindStringSubmatch(err.Error())
+
+	if match == nil || strings.HasPrefix(err.Error(), "error refreshing token") {
+		return 0
+	}
+
+	code, _ := strconv.Atoi(match[1])
+	return code
+}
+
+// isPermanentError returns whether Pirsch rejected the hit itself, so that retrying it won't help.
+func isPermanentError(err error) bool {
+	code := getStatusCode(err)
+	return code >= 400 && code < 500 &&
+		code != http.StatusUnauthorized &&
+		code != http.StatusRequestTimeout &&
+		code != http.StatusTooManyRequests
+}
+
+// isBreakerFailure returns whether the error indicates that the client is unavailable.
+// Hits rejected because of their own data are not counted, as they could be sent by anyone to open the breaker.
+func isBreakerFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	code := getStatusCode(err)
+	return code == 0 ||
+		code >= 500 ||
+		code == http.StatusUnauthorized ||
+		code == http.StatusTooManyRequests
+}
+
+func getClientName(c Client) string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	hash := sha256.Sum256([]byte(c.ID + c.Secret))
+	return hex.EncodeToString(hash[:])[:12]
 }
 
 func createFilter(config ClientFilter) []FilterFunc {
@@ -2,7 +2,6 @@ package proxy
 
 import (
 	"log/slog"
-	"net"
 	"os"
 	"strings"
 
@@ -14,30 +13,59 @@ var (
 )
 
 type Config struct {
-	Server       Server   `toml:"server"`
-	Clients      []Client `toml:"clients"`
-	Network      Network  `toml:"network"`
-	BaseURL      string   `toml:"base_url"`
-	BasePath     string   `toml:"base_path"`
-	PageViewPath string   `toml:"page_view_path"`
-	EventPath    string   `toml:"event_path"`
-	SessionPath  string   `toml:"session_path"`
-	JSFilename   string   `toml:"js_filename"`
+	Server        Server        `toml:"server"`
+	Clients       []Client      `toml:"clients"`
+	Network       Network       `toml:"network"`
+	Queue         Queue         `toml:"queue"`
+	Breaker       Breaker       `toml:"circuit_breaker"`
+	Admin         Admin         `toml:"admin"`
+	Script        Script        `toml:"script"`
+	Scripts       []ScriptFile  `toml:"scripts"`
+	Redirect      Redirect      `toml:"redirect"`
+	Batch         Batch         `toml:"batch"`
+	API           API           `toml:"api"`
+	GA4           GA4           `toml:"ga4"`
+	Plausible     Plausible     `toml:"plausible"`
+	Matomo        Matomo        `toml:"matomo"`
+	Stats         Stats         `toml:"stats"`
+	Bots          Bots          `toml:"bots"`
+	RateLimit     RateLimit     `toml:"rate_limit"`
+	IPFilter      IPFilter      `toml:"ip_filter"`
+	TrackingToken TrackingToken `toml:"tracking_token"`
+	BaseURL       string        `toml:"base_url"`
+	BasePath      string        `toml:"base_path"`
+	PageViewPath  string        `toml:"page_view_path"`
+	PixelPath     string        `toml:"pixel_path"`
+	EventPath     string        `toml:"event_path"`
+	SessionPath   string        `toml:"session_path"`
+	JSFilename    string        `toml:"js_filename"`
+	Policy        string        `toml:"policy"`
+
+	AllowedOrigins     []string `toml:"allowed_origins"`
+	LogRejectedOrigins bool     `toml:"log_rejected_origins"`
+	CheckPixelOrigin   bool     `toml:"check_pixel_origin"`
 }
 
 type Server struct {
 	Host         string `toml:"host"`
 	WriteTimeout int    `toml:"write_timeout"`
 	ReadTimeout  int    `toml:"read_timeout"`
+	MaxBodySize  int    `toml:"max_body_size"`
 	TLS          bool   `toml:"tls"`
 	TLSCert      string `toml:"tls_cert"`
 	TLSKey       string `toml:"tls_key"`
 }
 
 type Client struct {
-	ID     string       `toml:"id"`
-	Secret string       `toml:"secret"`
-	Filter ClientFilter `toml:"filter"`
+	Name     string       `toml:"name"`
+	ID       string       `toml:"id"`
+	Secret   string       `toml:"secret"`
+	Filter   ClientFilter `toml:"filter"`
+	Tags     ClientTags   `toml:"tags"`
+	IPFilter IPFilter     `toml:"ip_filter"`
+
+	// FilterBots overrides the bot filter configuration for this client if set.
+	FilterBots *bool `toml:"filter_bots"`
 }
 
 type ClientFilter struct {
@@ -46,22 +74,158 @@ type ClientFilter struct {
 	IdentificationCode []string `toml:"identification_code"`
 }
 
+type ClientTags struct {
+	Allowed   []string `toml:"allowed"`
+	MaxLength int      `toml:"max_length"`
+}
+
 type Network struct {
 	Header  []string `toml:"header"`
 	Subnets []string `toml:"subnets"`
 }
 
+type Queue struct {
+	Path          string `toml:"path"`
+	MaxSize       int    `toml:"max_size"`
+	SegmentSize   int    `toml:"segment_size"`
+	MaxAge        int    `toml:"max_age"`
+	RetryInterval int    `toml:"retry_interval"`
+}
+
+type Script struct {
+	SourceURL string `toml:"source_url"`
+	CachePath string `toml:"cache_path"`
+	SHA256    string `toml:"sha256"`
+	TTL       int    `toml:"ttl"`
+	MaxAge    int    `toml:"max_age"`
+	Offline   bool   `toml:"offline"`
+
+	Rewrite            bool   `toml:"rewrite"`
+	IdentificationCode string `toml:"identification_code"`
+}
+
+type ScriptFile struct {
+	File     string `toml:"file"`
+	Filename string `toml:"filename"`
+	TTL      int    `toml:"ttl"`
+	SHA256   string `toml:"sha256"`
+	Rewrite  bool   `toml:"rewrite"`
+}
+
+type Redirect struct {
+	Path              string   `toml:"path"`
+	EventName         string   `toml:"event_name"`
+	DownloadEventName string   `toml:"download_event_name"`
+	Hostnames         []string `toml:"hostnames"`
+	Secret            string   `toml:"secret"`
+}
+
+type Batch struct {
+	Path     string `toml:"path"`
+	MaxItems int    `toml:"max_items"`
+}
+
+type API struct {
+	Path string   `toml:"path"`
+	Keys []APIKey `toml:"keys"`
+}
+
+type APIKey struct {
+	Name    string   `toml:"name"`
+	Key     string   `toml:"key"`
+	Clients []string `toml:"clients"`
+}
+
+type GA4 struct {
+	Enabled        bool                `toml:"enabled"`
+	Path           string              `toml:"path"`
+	APISecrets     []string            `toml:"api_secrets"`
+	MeasurementIDs map[string][]string `toml:"measurement_ids"`
+}
+
+type Plausible struct {
+	Enabled bool   `toml:"enabled"`
+	Path    string `toml:"path"`
+}
+
+type Matomo struct {
+	Enabled bool                `toml:"enabled"`
+	Path    string              `toml:"path"`
+	Sites   map[string][]string `toml:"sites"`
+}
+
+type Stats struct {
+	Client      string         `toml:"client"`
+	Path        string         `toml:"path"`
+	Tokens      []string       `toml:"tokens"`
+	TTL         int            `toml:"ttl"`
+	EndpointTTL map[string]int `toml:"endpoint_ttl"`
+	MaxEntries  int            `toml:"max_entries"`
+}
+
+type Bots struct {
+	Enabled        bool   `toml:"enabled"`
+	File           string `toml:"file"`
+	ReloadInterval int    `toml:"reload_interval"`
+	MissingHeaders bool   `toml:"missing_headers"`
+}
+
+type RateLimit struct {
+	MaxEntries int           `toml:"max_entries"`
+	PageView   RateLimitRule `toml:"page_view"`
+	Event      RateLimitRule `toml:"event"`
+	Session    RateLimitRule `toml:"session"`
+	Script     RateLimitRule `toml:"script"`
+	Stats      RateLimitRule `toml:"stats"`
+}
+
+type RateLimitRule struct {
+	Rate          float64 `toml:"rate"`
+	Burst         int     `toml:"burst"`
+	HostnameRate  float64 `toml:"hostname_rate"`
+	HostnameBurst int     `toml:"hostname_burst"`
+}
+
+type IPFilter struct {
+	Allow          []string `toml:"allow"`
+	AllowFiles     []string `toml:"allow_files"`
+	Block          []string `toml:"block"`
+	BlockFiles     []string `toml:"block_files"`
+	ReloadInterval int      `toml:"reload_interval"`
+}
+
+type TrackingToken struct {
+	Secret string `toml:"secret"`
+	Cookie string `toml:"cookie"`
+}
+
+type Breaker struct {
+	Threshold int `toml:"threshold"`
+	Timeout   int `toml:"timeout"`
+}
+
+type Admin struct {
+	Path  string `toml:"path"`
+	Token string `toml:"token"`
+}
+
 // GetConfig returns the configuration.
 func GetConfig() *Config {
 	return config
 }
 
 // LoadConfig loads the toml configuration file.
+// The path can be passed as an argument. Passing --offline disables downloading scripts.
 func LoadConfig() {
 	path := "config.toml"
+	offline := false
 
-	if len(os.Args) > 1 {
-		path = os.Args[1]
+	for _, arg := range os.Args[1:] {
+		if arg == "--offline" {
+			offline = true
+		} else {
+			path = arg
+		}
 	}
 
 	data, err := os.ReadFile(path)
@@ -85,6 +249,10 @@ func LoadConfig() {
 		cfg.Server.ReadTimeout = 5
 	}
 
+	if cfg.Server.MaxBodySize == 0 {
+		cfg.Server.MaxBodySize = 64
+	}
+
 	if cfg.BasePath == "" {
 		cfg.BasePath = "/p"
 	}
@@ -93,6 +261,10 @@ func LoadConfig() {
 		cfg.PageViewPath = "pv"
 	}
 
+	if cfg.PixelPath == "" {
+		cfg.PixelPath = "px"
+	}
+
 	if cfg.EventPath == "" {
 		cfg.EventPath = "e"
 	}
@@ -105,6 +277,146 @@ func LoadConfig() {
 		cfg.JSFilename = "pa.js"
 	}
 
+	if cfg.Policy == "" {
+		cfg.Policy = policyAll
+	} else if cfg.Policy != policyAll && cfg.Policy != policyAny {
+		slog.Error("Policy invalid", "policy", cfg.Policy)
+		panic("Policy invalid")
+	}
+
+	if cfg.Queue.MaxSize == 0 {
+		cfg.Queue.MaxSize = 100
+	}
+
+	if cfg.Queue.SegmentSize == 0 {
+		cfg.Queue.SegmentSize = 1
+	}
+
+	if cfg.Queue.MaxAge == 0 {
+		cfg.Queue.MaxAge = 24
+	}
+
+	if cfg.Queue.RetryInterval == 0 {
+		cfg.Queue.RetryInterval = 5
+	}
+
+	loadClients(cfg)
+
+	if offline {
+		cfg.Script.Offline = true
+	}
+
+	if cfg.Script.SourceURL == "" {
+		if cfg.BaseURL != "" {
+			cfg.Script.SourceURL = cfg.BaseURL
+		} else {
+			cfg.Script.SourceURL = defaultScriptSourceURL
+		}
+	}
+
+	if cfg.Script.TTL == 0 {
+		cfg.Script.TTL = 3600
+	}
+
+	if cfg.Script.MaxAge == 0 {
+		cfg.Script.MaxAge = 3600
+	}
+
+	loadScripts(cfg)
+
+	if cfg.Redirect.Path == "" {
+		cfg.Redirect.Path = "r"
+	}
+
+	if cfg.Redirect.EventName == "" {
+		cfg.Redirect.EventName = "Outbound Link"
+	}
+
+	if cfg.Redirect.DownloadEventName == "" {
+		cfg.Redirect.DownloadEventName = "File Download"
+	}
+
+	if cfg.Batch.Path == "" {
+		cfg.Batch.Path = "b"
+	}
+
+	if cfg.Batch.MaxItems == 0 {
+		cfg.Batch.MaxItems = 50
+	}
+
+	if cfg.API.Path == "" {
+		cfg.API.Path = "api"
+	}
+
+	loadAPIKeys(cfg)
+
+	if cfg.GA4.Path == "" {
+		cfg.GA4.Path = "/mp/collect"
+	}
+
+	loadGA4(cfg)
+
+	if cfg.Plausible.Path == "" {
+		cfg.Plausible.Path = "/api/event"
+	}
+
+	if cfg.Matomo.Path == "" {
+		cfg.Matomo.Path = "/matomo.php"
+	}
+
+	loadMatomo(cfg)
+
+	if cfg.Stats.Path == "" {
+		cfg.Stats.Path = "stats"
+	}
+
+	if cfg.Stats.TTL == 0 {
+		cfg.Stats.TTL = 300
+	}
+
+	if cfg.Stats.MaxEntries == 0 {
+		cfg.Stats.MaxEntries = 1000
+	}
+
+	loadStats(cfg)
+
+	if cfg.TrackingToken.Cookie == "" {
+		cfg.TrackingToken.Cookie = "pirsch_token"
+	}
+
+	loadTrackingToken(cfg)
+
+	if cfg.Bots.ReloadInterval == 0 {
+		cfg.Bots.ReloadInterval = defaultBotReloadSeconds
+	}
+
+	if cfg.IPFilter.ReloadInterval == 0 {
+		cfg.IPFilter.ReloadInterval = 60
+	}
+
+	if cfg.RateLimit.MaxEntries == 0 {
+		cfg.RateLimit.MaxEntries = 10000
+	}
+
+	// the statistics endpoints are public and use the API quota, so they are limited by default
+	if cfg.RateLimit.Stats.Rate == 0 {
+		cfg.RateLimit.Stats = RateLimitRule{Rate: 1, Burst: 10}
+	}
+
+	loadRateLimit(cfg)
+
+	if cfg.Breaker.Threshold == 0 {
+		cfg.Breaker.Threshold = 5
+	}
+
+	if cfg.Breaker.Timeout == 0 {
+		cfg.Breaker.Timeout = 30
+	}
+
+	if cfg.Admin.Path == "" {
+		cfg.Admin.Path = "/admin"
+	}
+
 	loadIPHeader(cfg)
 	loadSubnets(cfg)
 	config = cfg
@@ -135,9 +447,159 @@ func loadIPHeader(config *Config) {
 	}
 }
 
+func loadClients(config *Config) {
+	names := make(map[string]bool, len(config.Clients))
+
+	for _, c := range config.Clients {
+		name := getClientName(c)
+
+		if names[name] {
+			slog.Error("Client name used more than once", "name", name, "id", c.ID)
+			panic("Client name used more than once")
+		}
+
+		names[name] = true
+	}
+}
+
+func loadScripts(config *Config) {
+	filenames := map[string]bool{config.JSFilename: true}
+
+	for i := range config.Scripts {
+		s := &config.Scripts[i]
+
+		if s.File == "" {
+			slog.Error("Script file missing", "index", i)
+			panic("Script file missing")
+		}
+
+		if s.Filename == "" {
+			s.Filename = s.File
+		}
+
+		if s.TTL == 0 {
+			s.TTL = config.Script.TTL
+		}
+
+		if filenames[s.Filename] {
+			slog.Error("Script filename used more than once", "filename", s.Filename)
+			panic("Script filename used more than once")
+		}
+
+		filenames[s.Filename] = true
+	}
+}
+
+func loadAPIKeys(config *Config) {
+	names := getClientNames(config)
+
+	for i, key := range config.API.Keys {
+		if key.Key == "" {
+			slog.Error("API key missing", "index", i, "name", key.Name)
+			panic("API key missing")
+		}
+
+		for _, name := range key.Clients {
+			if !names[name] {
+				slog.Error("API key client not found", "name", key.Name, "client", name)
+				panic("API key client not found")
+			}
+		}
+	}
+}
+
+func loadGA4(config *Config) {
+	names := getClientNames(config)
+
+	for id, clients := range config.GA4.MeasurementIDs {
+		for _, name := range clients {
+			if !names[name] {
+				slog.Error("Measurement ID client not found", "measurement_id", id, "client", name)
+				panic("Measurement ID client not found")
+			}
+		}
+	}
+}
+
+func loadMatomo(config *Config) {
+	names := getClientNames(config)
+
+	for id, clients := range config.Matomo.Sites {
+		for _, name := range clients {
+			if !names[name] {
+				slog.Error("Matomo site client not found", "idsite", id, "client", name)
+				panic("Matomo site client not found")
+			}
+		}
+	}
+}
+
+func loadStats(config *Config) {
+	if config.Stats.Client == "" {
+		return
+	}
+
+	if !getClientNames(config)[config.Stats.Client] {
+		slog.Error("Statistics client not found", "client", config.Stats.Client)
+		panic("Statistics client not found")
+	}
+
+	if len(config.Stats.Tokens) == 0 {
+		slog.Error("Statistics token missing")
+		panic("Statistics token missing")
+	}
+
+	for endpoint := range config.Stats.EndpointTTL {
+		if _, found := statsEndpoints[endpoint]; !found {
+			slog.Error("Statistics endpoint invalid", "endpoint", endpoint)
+			panic("Statistics endpoint invalid")
+		}
+	}
+
+	if config.Stats.MaxEntries <= 0 {
+		slog.Error("Statistics max entries must be positive", "max_entries", config.Stats.MaxEntries)
+		panic("Statistics max entries must be positive")
+	}
+}
+
+// loadTrackingToken makes sure that no endpoint accepts unauthenticated hits without a token if tracking tokens are enabled.
+// The Plausible and Matomo endpoints can't pass tokens and the GA4 endpoint must require an API secret instead.
+func loadTrackingToken(config *Config) {
+	if config.TrackingToken.Secret == "" {
+		return
+	}
+
+	if config.Plausible.Enabled || config.Matomo.Enabled {
+		slog.Error("Tracking tokens cannot be enabled together with the Plausible or Matomo endpoint")
+		panic("Tracking tokens cannot be enabled together with the Plausible or Matomo endpoint")
+	}
+
+	if config.GA4.Enabled && len(config.GA4.APISecrets) == 0 {
+		slog.Error("Tracking tokens require API secrets for the GA4 endpoint")
+		panic("Tracking tokens require API secrets for the GA4 endpoint")
+	}
+}
+
+func getClientNames(config *Config) map[string]bool {
+	names := make(map[string]bool, len(config.Clients))
+
+	for _, c := range config.Clients {
+		names[getClientName(c)] = true
+	}
+
+	return names
+}
+
+func loadRateLimit(config *Config) {
+	if config.RateLimit.MaxEntries <= 0 {
+		slog.Error("Rate limit max entries must be positive", "max_entries", config.RateLimit.MaxEntries)
+		panic("Rate limit max entries must be positive")
+	}
+}
+
 func loadSubnets(config *Config) {
 	for _, subnet := range config.Network.Subnets {
-		_, n, err := net.ParseCIDR(subnet)
+		n, err := parseSubnet(subnet)
 
 		if err != nil {
 			slog.Error("Error parsing subnet", "err", err, "subnet", subnet)
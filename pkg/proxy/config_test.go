@@ -17,9 +17,153 @@ func TestLoadIPHeader(t *testing.T) {
 
 func TestLoadSubnets(t *testing.T) {
 	config := new(Config)
-	config.Network.Subnets = []string{"10.0.0.1/8", "123.56.98.42/16"}
+	config.Network.Subnets = []string{"10.0.0.1/8", "123.56.98.42/16", "203.0.113.7"}
 	loadSubnets(config)
-	assert.Len(t, allowedSubnets, 2)
+	assert.Len(t, allowedSubnets, 3)
 	assert.Equal(t, "10.0.0.0/8", allowedSubnets[0].String())
 	assert.Equal(t, "123.56.0.0/16", allowedSubnets[1].String())
+	assert.Equal(t, "203.0.113.7/32", allowedSubnets[2].String())
+	allowedSubnets = nil
+}
+
+func TestLoadClients(t *testing.T) {
+	config := new(Config)
+	config.Clients = []Client{{Name: "blog"}, {ID: "id", Secret: "secret"}}
+	assert.NotPanics(t, func() {
+		loadClients(config)
+	})
+	config.Clients = append(config.Clients, Client{Name: "blog", ID: "other", Secret: "other"})
+	assert.Panics(t, func() {
+		loadClients(config)
+	})
+	config.Clients = []Client{{ID: "id", Secret: "secret"}, {ID: "id", Secret: "secret"}}
+	assert.Panics(t, func() {
+		loadClients(config)
+	})
+}
+
+func TestLoadScripts(t *testing.T) {
+	config := new(Config)
+	config.JSFilename = "pa.js"
+	config.Script.TTL = 3600
+	config.Scripts = []ScriptFile{
+		{File: "pirsch-extended.js"},
+		{File: "pa.js", Filename: "legacy.js", TTL: 60, Rewrite: true},
+	}
+	loadScripts(config)
+	assert.Equal(t, "pirsch-extended.js", config.Scripts[0].Filename)
+	assert.Equal(t, 3600, config.Scripts[0].TTL)
+	assert.Equal(t, "legacy.js", config.Scripts[1].Filename)
+	assert.Equal(t, 60, config.Scripts[1].TTL)
+	config.Scripts = append(config.Scripts, ScriptFile{File: "pa.js"})
+	assert.Panics(t, func() {
+		loadScripts(config)
+	})
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	config := new(Config)
+	config.Clients = []Client{{Name: "blog"}, {ID: "id", Secret: "secret"}}
+	config.API.Keys = []APIKey{{Name: "checkout", Key: "key", Clients: []string{"blog", getClientName(config.Clients[1])}}}
+	assert.NotPanics(t, func() {
+		loadAPIKeys(config)
+	})
+	config.API.Keys = append(config.API.Keys, APIKey{Name: "gateway", Key: "other", Clients: []string{"unknown"}})
+	assert.Panics(t, func() {
+		loadAPIKeys(config)
+	})
+	config.API.Keys = []APIKey{{Name: "gateway"}}
+	assert.Panics(t, func() {
+		loadAPIKeys(config)
+	})
+}
+
+func TestLoadGA4(t *testing.T) {
+	config := new(Config)
+	config.Clients = []Client{{Name: "blog"}}
+	config.GA4.MeasurementIDs = map[string][]string{"G-BLOG": {"blog"}}
+	assert.NotPanics(t, func() {
+		loadGA4(config)
+	})
+	config.GA4.MeasurementIDs["G-SHOP"] = []string{"shop"}
+	assert.Panics(t, func() {
+		loadGA4(config)
+	})
+}
+
+func TestLoadMatomo(t *testing.T) {
+	config := new(Config)
+	config.Clients = []Client{{Name: "blog"}}
+	config.Matomo.Sites = map[string][]string{"1": {"blog"}}
+	assert.NotPanics(t, func() {
+		loadMatomo(config)
+	})
+	config.Matomo.Sites["2"] = []string{"shop"}
+	assert.Panics(t, func() {
+		loadMatomo(config)
+	})
+}
+
+func TestLoadStats(t *testing.T) {
+	config := new(Config)
+	config.Clients = []Client{{Name: "blog"}}
+	config.Stats = Stats{Client: "blog", Tokens: []string{"token"}, EndpointTTL: map[string]int{"active_visitors": 10}, MaxEntries: 10}
+	assert.NotPanics(t, func() {
+		loadStats(config)
+	})
+	config.Stats.MaxEntries = -1
+	assert.Panics(t, func() {
+		loadStats(config)
+	})
+	config.Stats.MaxEntries = 10
+	config.Stats.EndpointTTL["unknown"] = 10
+	assert.Panics(t, func() {
+		loadStats(config)
+	})
+	config.Stats = Stats{Client: "blog"}
+	assert.Panics(t, func() {
+		loadStats(config)
+	})
+	config.Stats = Stats{Client: "shop", Tokens: []string{"token"}}
+	assert.Panics(t, func() {
+		loadStats(config)
+	})
+}
+
+func TestLoadTrackingToken(t *testing.T) {
+	config := new(Config)
+	config.Plausible.Enabled = true
+	config.Matomo.Enabled = true
+	config.GA4.Enabled = true
+	assert.NotPanics(t, func() {
+		loadTrackingToken(config)
+	})
+	config.TrackingToken.Secret = "secret"
+	assert.Panics(t, func() {
+		loadTrackingToken(config)
+	})
+	config.Plausible.Enabled = false
+	assert.Panics(t, func() {
+		loadTrackingToken(config)
+	})
+	config.Matomo.Enabled = false
+	assert.Panics(t, func() {
+		loadTrackingToken(config)
+	})
+	config.GA4.APISecrets = []string{"secret"}
+	assert.NotPanics(t, func() {
+		loadTrackingToken(config)
+	})
+}
+
+func TestLoadRateLimit(t *testing.T) {
+	config := new(Config)
+	config.RateLimit.MaxEntries = 10
+	assert.NotPanics(t, func() {
+		loadRateLimit(config)
+	})
+	config.RateLimit.MaxEntries = -1
+	assert.Panics(t, func() {
+		loadRateLimit(config)
+	})
 }
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	watchers     []chan struct{}
+	watchersDone sync.WaitGroup
+	watchersM    sync.Mutex
+)
+
+// watchFile loads the file and reloads it whenever it has been modified.
+// The file is checked for changes in the given interval. If reloading fails, the error is logged and the
+// previously loaded content is kept, so that a broken file doesn't disable anything at runtime.
+// The watcher runs until stopWatchers is called.
+func watchFile(path string, interval time.Duration, load func([]byte) error) error {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err := load(data); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	watchersM.Lock()
+	watchers = append(watchers, done)
+	watchersDone.Add(1)
+	watchersM.Unlock()
+
+	go func() {
+		defer watchersDone.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		modTime, size := info.ModTime(), info.Size()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+
+			if err != nil {
+				slog.Error("Error checking file for changes", "err", err, "path", path)
+				continue
+			}
+
+			if info.ModTime().Equal(modTime) && info.Size() == size {
+				continue
+			}
+
+			modTime, size = info.ModTime(), info.Size()
+			data, err := os.ReadFile(path)
+
+			if err != nil {
+				slog.Error("Error reading file", "err", err, "path", path)
+				continue
+			}
+
+			if err := load(data); err != nil {
+				slog.Error("Error reloading file", "err", err, "path", path)
+				continue
+			}
+
+			slog.Info("File reloaded", "path", path)
+		}
+	}()
+
+	return nil
+}
+
+// stopWatchers stops all file watchers and waits for them to return.
+func stopWatchers() {
+	watchersM.Lock()
+
+	for _, done := range watchers {
+		close(done)
+	}
+
+	watchers = nil
+	watchersM.Unlock()
+	watchersDone.Wait()
+}
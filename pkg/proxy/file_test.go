@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patterns.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("a"), 0600))
+	var content atomic.Value
+	load := func(data []byte) error {
+		content.Store(string(data))
+		return nil
+	}
+	assert.NoError(t, watchFile(path, time.Millisecond*10, load))
+	assert.Equal(t, "a", content.Load())
+	assert.NoError(t, os.WriteFile(path, []byte("ab"), 0600))
+	assert.Eventually(t, func() bool {
+		return content.Load() == "ab"
+	}, time.Second, time.Millisecond*10)
+	assert.Error(t, watchFile(filepath.Join(t.TempDir(), "missing.txt"), time.Second, load))
+
+	// changes are no longer loaded once the watcher has been stopped
+	stopWatchers()
+	assert.Empty(t, watchers)
+	assert.NoError(t, os.WriteFile(path, []byte("abc"), 0600))
+	time.Sleep(time.Millisecond * 30)
+	assert.Equal(t, "ab", content.Load())
+}
@@ -2,27 +2,24 @@ package proxy
 
 import (
 	"log/slog"
-	"net/url"
 	"regexp"
 	"strings"
 )
 
 // FilterFunc is a client filter function.
-// Returns true if the filter applies to the request URL.
-type FilterFunc func(*url.URL) bool
+// Returns true if the filter applies to the hit.
+type FilterFunc func(*Hit) bool
 
 // NewHostnameFilter returns a new FilterFunc filtering on the hostname.
 // This function supports regex filters via the "regex:" prefix.
 func NewHostnameFilter(hostnames []string) FilterFunc {
 	directMatch, regexMatch := getMatchers(hostnames)
-	return func(url *url.URL) bool {
-		u := getURL(url)
-
-		if u == nil {
+	return func(hit *Hit) bool {
+		if hit.PageURL() == nil {
 			return false
 		}
 
-		hostname := strings.ToLower(u.Hostname())
+		hostname := hit.Hostname()
 
 		for _, match := range directMatch {
 			if hostname == match {
@@ -44,14 +41,12 @@ func NewHostnameFilter(hostnames []string) FilterFunc {
 // This function supports regex filters via the "regex:" prefix.
 func NewPathFilter(paths []string) FilterFunc {
 	directMatch, regexMatch := getMatchers(paths)
-	return func(url *url.URL) bool {
-		u := getURL(url)
-
-		if u == nil {
+	return func(hit *Hit) bool {
+		if hit.PageURL() == nil {
 			return false
 		}
 
-		path := strings.ToLower(u.Path)
+		path := hit.Path()
 
 		for _, match := range directMatch {
 			if path == match {
@@ -69,13 +64,11 @@ func NewPathFilter(paths []string) FilterFunc {
 	}
 }
 
-// NewIdentificationCodeFilter returns a new FilterFunc filtering on the identification code query parameter.
+// NewIdentificationCodeFilter returns a new FilterFunc filtering on the identification code.
 func NewIdentificationCodeFilter(identificationCodes []string) FilterFunc {
-	return func(url *url.URL) bool {
-		id := url.Query().Get("code")
-
+	return func(hit *Hit) bool {
 		for _, match := range identificationCodes {
-			if id == match {
+			if hit.Code == match {
 				return true
 			}
 		}
@@ -105,13 +98,3 @@ func getMatchers(matchers []string) ([]string, []regexp.Regexp) {
 
 	return directMatch, regexMatch
 }
-
-func getURL(url *url.URL) *url.URL {
-	u, err := url.Parse(url.Query().Get("url"))
-
-	if err != nil {
-		return nil
-	}
-
-	return u
-}
@@ -1,7 +1,8 @@
 package proxy
 
 import (
-	"net/url"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,16 +13,11 @@ func TestHostnameFilter(t *testing.T) {
 		"filtered.com",
 		"regex:[a-z]+\\.filtered\\.com",
 	})
-	u, _ := url.Parse("https://proxy.com/hit?url=https://example.com/")
-	assert.False(t, filter(u))
-	u, _ = url.Parse("https://proxy.com/hit?url=https://example.com/blog/article")
-	assert.False(t, filter(u))
-	u, _ = url.Parse("https://proxy.com/hit?url=https://filtered.com/blog/filtered")
-	assert.True(t, filter(u))
-	u, _ = url.Parse("https://proxy.com/hit?url=https://sub.filtered.com")
-	assert.True(t, filter(u))
-	u, _ = url.Parse("https://proxy.com/hit?url=https://01.filtered.com")
-	assert.False(t, filter(u))
+	assert.False(t, filter(getTestHit("https://proxy.com/hit?url=https://example.com/")))
+	assert.False(t, filter(getTestHit("https://proxy.com/hit?url=https://example.com/blog/article")))
+	assert.True(t, filter(getTestHit("https://proxy.com/hit?url=https://filtered.com/blog/filtered")))
+	assert.True(t, filter(getTestHit("https://proxy.com/hit?url=https://sub.filtered.com")))
+	assert.False(t, filter(getTestHit("https://proxy.com/hit?url=https://01.filtered.com")))
 }
 
 func TestPathFilter(t *testing.T) {
@@ -29,16 +25,11 @@ func TestPathFilter(t *testing.T) {
 		"/blog/filtered",
 		"regex:\\/glossary\\/[0-9]+",
 	})
-	u, _ := url.Parse("https://proxy.com/hit?url=https://example.com/")
-	assert.False(t, filter(u))
-	u, _ = url.Parse("https://proxy.com/hit?url=https://example.com/blog/article")
-	assert.False(t, filter(u))
-	u, _ = url.Parse("https://proxy.com/hit?url=https://example.com/blog/filtered")
-	assert.True(t, filter(u))
-	u, _ = url.Parse("https://proxy.com/hit?url=https://example.com/glossary/9342589")
-	assert.True(t, filter(u))
-	u, _ = url.Parse("https://proxy.com/hit?url=https://example.com/glossary/e9342589")
-	assert.False(t, filter(u))
+	assert.False(t, filter(getTestHit("https://proxy.com/hit?url=https://example.com/")))
+	assert.False(t, filter(getTestHit("https://proxy.com/hit?url=https://example.com/blog/article")))
+	assert.True(t, filter(getTestHit("https://proxy.com/hit?url=https://example.com/blog/filtered")))
+	assert.True(t, filter(getTestHit("https://proxy.com/hit?url=https://example.com/glossary/9342589")))
+	assert.False(t, filter(getTestHit("https://proxy.com/hit?url=https://example.com/glossary/e9342589")))
 }
 
 func TestIdentificationCodeFilter(t *testing.T) {
@@ -46,10 +37,20 @@ func TestIdentificationCodeFilter(t *testing.T) {
 		"abc123",
 		"efg456",
 	})
-	u, _ := url.Parse("https://proxy.com/hit?code=123456")
-	assert.False(t, filter(u))
-	u, _ = url.Parse("https://proxy.com/hit?code=abc123")
-	assert.True(t, filter(u))
-	u, _ = url.Parse("https://proxy.com/hit?code=efg456")
-	assert.True(t, filter(u))
+	assert.False(t, filter(getTestHit("https://proxy.com/hit?code=123456")))
+	assert.True(t, filter(getTestHit("https://proxy.com/hit?code=abc123")))
+	assert.True(t, filter(getTestHit("https://proxy.com/hit?code=efg456")))
+}
+
+func TestEventFilter(t *testing.T) {
+	filter := NewHostnameFilter([]string{"example.com"})
+	req := httptest.NewRequest(http.MethodPost, "https://proxy.com/e", nil)
+	hit := newHit(eventHit, req)
+	assert.False(t, filter(hit))
+	hit.Options.URL = "https://example.com/foo"
+	assert.True(t, filter(hit))
+}
+
+func getTestHit(rawURL string) *Hit {
+	return getPageViewHit(httptest.NewRequest(http.MethodGet, rawURL, nil))
 }
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	ga4MaxEvents    = 25
+	ga4PageViewName = "page_view"
+)
+
+// ga4Params are the event parameters used to build the hit instead of being passed as event metadata.
+var ga4Params = []string{"page_location", "page_title", "page_referrer", "engagement_time_msec"}
+
+// ga4Request is a Google Analytics 4 Measurement Protocol request.
+// The timestamp_micros field is ignored, as Pirsch does not accept timestamps and the hits are recorded at the time they are delivered.
+type ga4Request struct {
+	ClientID   string     `json:"client_id"`
+	IPOverride string     `json:"ip_override"`
+	Device     ga4Device  `json:"device"`
+	Events     []ga4Event `json:"events"`
+}
+
+type ga4Device struct {
+	Language         string `json:"language"`
+	ScreenResolution string `json:"screen_resolution"`
+	UserAgent        string `json:"user_agent"`
+}
+
+type ga4Event struct {
+	Name   string         `json:"name"`
+	Params map[string]any `json:"params"`
+}
+
+// ga4Collect translates a Measurement Protocol request to page views and events.
+// The page_view event is sent as a page view and all other events as events with the parameters as metadata.
+// Like Google Analytics, it responds with 204 No Content on success.
+func ga4Collect(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if !validGA4Secret(query.Get("api_secret")) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var names []string
+
+	if len(config.GA4.MeasurementIDs) > 0 {
+		var found bool
+		names, found = config.GA4.MeasurementIDs[query.Get("measurement_id")]
+
+		if !found {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	body, err := readBody(w, r)
+
+	if err != nil {
+		w.WriteHeader(getBodyErrorStatus(err))
+		return
+	}
+
+	var req ga4Request
+
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Events) == 0 || len(req.Events) > ga4MaxEvents {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if req.IPOverride != "" && net.ParseIP(req.IPOverride) == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// the visitor IP and User-Agent can only be overridden by authenticated requests
+	trusted := len(config.GA4.APISecrets) > 0
+	hits := make([]*Hit, 0, len(req.Events))
+
+	for _, event := range req.Events {
+		hit := req.hit(r, event, trusted)
+
+		if err := hit.validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		hits = append(hits, hit)
+	}
+
+	// the events are delivered in order, but all of them are, even if one fails
+	status := http.StatusOK
+
+	for _, hit := range hits {
+		if s := getResponseStatus(fanOutTo(hit, getClientsByName(names)), config.Policy); s != http.StatusOK && status == http.StatusOK {
+			status = s
+		}
+	}
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validGA4Secret(secret string) bool {
+	if len(config.GA4.APISecrets) == 0 {
+		return true
+	}
+
+	for _, s := range config.GA4.APISecrets {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(s)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hit creates a hit for the event.
+// The visitor data is taken from the request body if set and from the request otherwise.
+// The IP and User-Agent are only taken from the body if the request is trusted.
+func (req *ga4Request) hit(r *http.Request, event ga4Event, trusted bool) *Hit {
+	kind := eventHit
+
+	if event.Name == ga4PageViewName {
+		kind = pageViewHit
+	}
+
+	hit := newHit(kind, r)
+	hit.ServerSide = true
+
+	if trusted && req.IPOverride != "" {
+		hit.Options.IP = req.IPOverride
+	}
+
+	if trusted && req.Device.UserAgent != "" {
+		hit.Options.UserAgent = req.Device.UserAgent
+	}
+
+	if req.Device.Language != "" {
+		hit.Options.AcceptLanguage = req.Device.Language
+	}
+
+	if width, height, found := strings.Cut(req.Device.ScreenResolution, "x"); found {
+		hit.Options.ScreenWidth, _ = strconv.Atoi(width)
+		hit.Options.ScreenHeight, _ = strconv.Atoi(height)
+	}
+
+	hit.Options.URL = getGA4Param(event.Params, "page_location")
+	hit.Options.Title = getGA4Param(event.Params, "page_title")
+	hit.Options.Referrer = getGA4Param(event.Params, "page_referrer")
+
+	if kind == eventHit {
+		hit.EventName = event.Name
+		duration, _ := strconv.Atoi(getGA4Param(event.Params, "engagement_time_msec"))
+		hit.EventDuration = duration / 1000
+		hit.EventMeta = getGA4Meta(event.Params)
+	}
+
+	return hit
+}
+
+func getGA4Meta(params map[string]any) map[string]string {
+	meta := make(map[string]string)
+
+	for key := range params {
+		if !isGA4Param(key) {
+			meta[key] = getGA4Param(params, key)
+		}
+	}
+
+	if len(meta) == 0 {
+		return nil
+	}
+
+	return meta
+}
+
+func isGA4Param(key string) bool {
+	for _, param := range ga4Params {
+		if key == param {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getGA4Param(params map[string]any, key string) string {
+	return formatValue(params[key])
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGA4Collect(t *testing.T) {
+	mock, server := newAPIMock(t)
+	otherMock, otherServer := newAPIMock(t)
+	setTestClients(t, newTestClient("blog", server), newTestClient("shop", otherServer))
+	config.GA4 = GA4{
+		APISecrets:     []string{"secret"},
+		MeasurementIDs: map[string][]string{"G-BLOG": {"blog"}},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/mp/collect?measurement_id=G-BLOG&api_secret=secret", strings.NewReader(`{
+		"client_id": "123.456",
+		"ip_override": "203.0.113.7",
+		"device": {"user_agent": "Mozilla/5.0", "language": "de-DE", "screen_resolution": "1920x1080"},
+		"events": [
+			{"name": "page_view", "params": {"page_location": "https://example.com/foo", "page_title": "Foo", "page_referrer": "https://google.com"}},
+			{"name": "purchase", "params": {"page_location": "https://example.com/checkout", "engagement_time_msec": 4200, "value": 42.5, "currency": "EUR", "coupon": true}}
+		]
+	}`))
+	w := httptest.NewRecorder()
+	ga4Collect(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, otherMock.received())
+	hits := mock.received()
+	assert.Len(t, hits, 2)
+	pageView, event := 0, 1
+	assert.Equal(t, "https://example.com/foo", hits[pageView].URL)
+	assert.Equal(t, "Foo", hits[pageView].Title)
+	assert.Equal(t, "https://google.com", hits[pageView].Referrer)
+	assert.Equal(t, "203.0.113.7", hits[pageView].IP)
+	assert.Equal(t, "Mozilla/5.0", hits[pageView].UserAgent)
+	assert.Equal(t, "de-DE", hits[pageView].AcceptLanguage)
+	assert.Equal(t, 1920, hits[pageView].ScreenWidth)
+	assert.Equal(t, 1080, hits[pageView].ScreenHeight)
+	assert.Equal(t, "purchase", hits[event].Name)
+	assert.Equal(t, "https://example.com/checkout", hits[event].URL)
+	assert.Equal(t, 4, hits[event].DurationSeconds)
+	assert.Equal(t, map[string]string{"value": "42.5", "currency": "EUR", "coupon": "true"}, hits[event].Metadata)
+}
+
+func TestGA4CollectUntrusted(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("blog", server))
+	config.GA4 = GA4{}
+	req := httptest.NewRequest(http.MethodPost, "/mp/collect", strings.NewReader(`{
+		"ip_override": "203.0.113.7",
+		"device": {"user_agent": "Mozilla/5.0", "language": "de-DE"},
+		"events": [{"name": "page_view", "params": {"page_location": "https://example.com/foo"}}]
+	}`))
+	req.RemoteAddr = "198.51.100.1:1234"
+	req.Header.Set("User-Agent", "curl")
+	w := httptest.NewRecorder()
+	ga4Collect(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	hits := mock.received()
+	assert.Len(t, hits, 1)
+	assert.Equal(t, "198.51.100.1", hits[0].IP)
+	assert.Equal(t, "curl", hits[0].UserAgent)
+	assert.Equal(t, "de-DE", hits[0].AcceptLanguage)
+}
+
+func TestGA4Timestamp(t *testing.T) {
+	req := ga4Request{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"timestamp_micros": 1000000, "events": [{"name": "page_view"}]}`), &req))
+	hit := req.hit(httptest.NewRequest(http.MethodPost, "/mp/collect", nil), req.Events[0], false)
+	assert.WithinDuration(t, time.Now(), hit.Time, time.Second)
+}
+
+func TestGA4CollectInvalid(t *testing.T) {
+	_, server := newAPIMock(t)
+	setTestClients(t, newTestClient("blog", server))
+	config.GA4 = GA4{
+		APISecrets:     []string{"secret"},
+		MeasurementIDs: map[string][]string{"G-BLOG": {"blog"}},
+	}
+	pageView := `{"events": [{"name": "page_view", "params": {"page_location": "https://example.com"}}]}`
+
+	for query, status := range map[string]int{
+		"measurement_id=G-BLOG":                    http.StatusForbidden,
+		"measurement_id=G-BLOG&api_secret=invalid": http.StatusForbidden,
+		"measurement_id=G-OTHER&api_secret=secret": http.StatusForbidden,
+		"measurement_id=G-BLOG&api_secret=secret":  http.StatusNoContent,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/mp/collect?"+query, strings.NewReader(pageView))
+		w := httptest.NewRecorder()
+		ga4Collect(w, req)
+		assert.Equal(t, status, w.Code)
+	}
+
+	for _, body := range []string{
+		`{`,
+		`{"events": []}`,
+		`{"events": [{"name": "page_view"}]}`,
+		`{"ip_override": "invalid", "events": [{"name": "page_view", "params": {"page_location": "https://example.com"}}]}`,
+		`{"events": [` + strings.Repeat(`{"name": "click", "params": {"page_location": "https://example.com"}},`, ga4MaxEvents) + `{"name": "click"}]}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/mp/collect?measurement_id=G-BLOG&api_secret=secret", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		ga4Collect(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
@@ -1,196 +1,226 @@
 package proxy
 
 import (
-	"encoding/json"
-	"io"
-	"log/slog"
+	"errors"
 	"net/http"
 	"path/filepath"
 	"strconv"
-	"sync"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
-	"github.com/klauspost/compress/gzhttp"
-	pirsch "github.com/pirsch-analytics/pirsch-go-sdk/v2/pkg"
-)
-
-var (
-	pirschJS       []byte
-	updatePirschJS time.Time
-	m              sync.RWMutex
 )
 
 // GetRouter sets up and returns the router.
 func GetRouter() *chi.Mux {
 	router := chi.NewRouter()
-	router.Use(cors.Handler(cors.Options{
+	corsOptions := cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: true,
 		MaxAge:           86400, // one day
-	}))
-	router.Get(filepath.Join(config.BasePath, config.PageViewPath), pageView)
-	router.Post(filepath.Join(config.BasePath, config.EventPath), event)
-	router.Post(filepath.Join(config.BasePath, config.SessionPath), session)
-	serveScript(router, config.JSFilename, "pa.js", &pirschJS, &updatePirschJS)
-	return router
-}
-
-func serveScript(router *chi.Mux, filename, file string, content *[]byte, updateAt *time.Time) {
-	router.HandleFunc(filepath.Join(config.BasePath, filename), gzhttp.GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		m.RLock()
-
-		if content == nil || updateAt.Before(time.Now()) {
-			m.RUnlock()
-
-			if err := downloadFile(file, content, updateAt); err != nil {
-				slog.Error("Error downloading script", "err", err, "file", file)
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
+	}
+	checkOrigin := func(next http.Handler) http.Handler {
+		return next
+	}
+	checkPixelOrigin := checkOrigin
+	origins = nil
+
+	if len(config.AllowedOrigins) > 0 {
+		origins = newOriginFilter(config.AllowedOrigins)
+		corsOptions.AllowedOrigins = nil
+		corsOptions.AllowOriginFunc = origins.allowOrigin
+		checkOrigin = origins.check
+
+		// the pixel is also embedded in emails and RSS readers, which don't send an Origin or Referer
+		if config.CheckPixelOrigin {
+			checkPixelOrigin = origins.check
+		}
+	}
 
-			m.RLock()
+	router.Use(cors.Handler(corsOptions))
+	pageViewLimit := router.With(rateLimit(config.RateLimit.PageView))
+	eventLimit := router.With(rateLimit(config.RateLimit.Event))
+	pageViewLimit.With(checkOrigin).Get(filepath.Join(config.BasePath, config.PageViewPath), pageView)
+	pageViewLimit.With(checkPixelOrigin).Get(filepath.Join(config.BasePath, config.PixelPath), pixel)
+	eventLimit.With(checkOrigin).Post(filepath.Join(config.BasePath, config.EventPath), event)
+	router.With(rateLimit(config.RateLimit.Session), checkOrigin).Post(filepath.Join(config.BasePath, config.SessionPath), session)
+	eventLimit.With(checkOrigin).Post(filepath.Join(config.BasePath, config.Batch.Path), batch)
+	serveScripts(router.With(rateLimit(config.RateLimit.Script)))
+
+	if len(config.Redirect.Hostnames) > 0 || config.Redirect.Secret != "" {
+		var allowed FilterFunc
+
+		if len(config.Redirect.Hostnames) > 0 {
+			allowed = NewHostnameFilter(config.Redirect.Hostnames)
 		}
 
-		defer m.RUnlock()
+		eventLimit.Get(filepath.Join(config.BasePath, config.Redirect.Path), redirect(allowed))
+	}
 
-		if _, err := w.Write(*content); err != nil {
-			slog.Error("Error sending script", "err", err, "file", file)
-		}
-	})))
-}
+	if len(config.API.Keys) > 0 {
+		serveAPI(router)
+	}
 
-func downloadFile(file string, content *[]byte, updateAt *time.Time) error {
-	m.Lock()
-	defer m.Unlock()
-	*updateAt = time.Now().Add(time.Hour)
-	resp, err := http.Get("https://api.pirsch.io/" + file)
+	if config.GA4.Enabled {
+		pageViewLimit.Post(config.GA4.Path, ga4Collect)
+	}
 
-	if err != nil {
-		return err
+	if config.Plausible.Enabled {
+		pageViewLimit.With(checkOrigin).Post(config.Plausible.Path, plausible)
 	}
 
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-	data, err := io.ReadAll(resp.Body)
+	if config.Matomo.Enabled {
+		pageViewLimit.Get(config.Matomo.Path, matomo)
+		pageViewLimit.Post(config.Matomo.Path, matomo)
+	}
 
-	if err != nil {
-		return err
+	if config.Stats.Client != "" {
+		serveStats(router.With(rateLimit(config.RateLimit.Stats)))
 	}
 
-	*content = data
-	return nil
+	if config.Admin.Token != "" {
+		serveAdmin(router)
+	}
+
+	return router
 }
 
 func pageView(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()
-	width, _ := strconv.ParseInt(query.Get("w"), 10, 16)
-	height, _ := strconv.ParseInt(query.Get("h"), 10, 16)
-	options := &pirsch.PageViewOptions{
-		URL:                    query.Get("url"),
-		IP:                     getIP(r),
-		UserAgent:              r.Header.Get("User-Agent"),
-		AcceptLanguage:         r.Header.Get("Accept-Language"),
-		SecCHUA:                r.Header.Get("Sec-CH-UA"),
-		SecCHUAMobile:          r.Header.Get("Sec-CH-UA-Mobile"),
-		SecCHUAPlatform:        r.Header.Get("Sec-CH-UA-Platform"),
-		SecCHUAPlatformVersion: r.Header.Get("Sec-CH-UA-Platform-Version"),
-		SecCHWidth:             r.Header.Get("Sec-CH-Width"),
-		SecCHViewportWidth:     r.Header.Get("Sec-CH-Viewport-Width"),
-		Title:                  query.Get("t"),
-		Referrer:               query.Get("ref"),
-		ScreenWidth:            int(width),
-		ScreenHeight:           int(height),
-	}
-
-	for _, c := range clients {
-		if acceptRequest(c, r) {
-			if err := c.api.PageView(r, options); err != nil {
-				slog.Error("Error sending page view", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				break
-			}
-		}
+	hit := getPageViewHit(r)
+
+	if err := hit.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
+
+	if err := verifyToken(hit, getToken(r, r.URL.Query().Get("token"))); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	deliver(w, hit)
 }
 
 func event(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
+	data, err := readHitData(w, r)
 
 	if err != nil {
+		w.WriteHeader(getBodyErrorStatus(err))
+		return
+	}
+
+	if data == nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	e := struct {
-		URL           string            `json:"url"`
-		Title         string            `json:"title"`
-		Referrer      string            `json:"referrer"`
-		ScreenWidth   int               `json:"screen_width"`
-		ScreenHeight  int               `json:"screen_height"`
-		EventName     string            `json:"event_name"`
-		EventDuration int               `json:"event_duration"`
-		EventMeta     map[string]string `json:"event_meta"`
-	}{}
+	hit := newHit(eventHit, r)
+	data.apply(hit)
 
-	if err := json.Unmarshal(body, &e); err != nil {
+	if err := hit.validate(); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	options := &pirsch.PageViewOptions{
-		URL:                    e.URL,
-		IP:                     getIP(r),
-		UserAgent:              r.Header.Get("User-Agent"),
-		AcceptLanguage:         r.Header.Get("Accept-Language"),
-		SecCHUA:                r.Header.Get("Sec-CH-UA"),
-		SecCHUAMobile:          r.Header.Get("Sec-CH-UA-Mobile"),
-		SecCHUAPlatform:        r.Header.Get("Sec-CH-UA-Platform"),
-		SecCHUAPlatformVersion: r.Header.Get("Sec-CH-UA-Platform-Version"),
-		SecCHWidth:             r.Header.Get("Sec-CH-Width"),
-		SecCHViewportWidth:     r.Header.Get("Sec-CH-Viewport-Width"),
-		Title:                  e.Title,
-		Referrer:               e.Referrer,
-		ScreenWidth:            e.ScreenWidth,
-		ScreenHeight:           e.ScreenHeight,
-	}
-
-	for _, c := range clients {
-		if acceptRequest(c, r) {
-			if err := c.api.Event(e.EventName, e.EventDuration, e.EventMeta, r, options); err != nil {
-				slog.Error("Error sending event", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				break
-			}
-		}
+	if data.Token == "" {
+		data.Token = r.URL.Query().Get("token")
 	}
+
+	if err := verifyToken(hit, getToken(r, data.Token)); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	deliver(w, hit)
 }
 
 func session(w http.ResponseWriter, r *http.Request) {
-	for _, c := range clients {
-		if acceptRequest(c, r) {
-			options := &pirsch.PageViewOptions{
-				IP:             getIP(r),
-				UserAgent:      r.Header.Get("User-Agent"),
-				AcceptLanguage: r.Header.Get("Accept-Language"),
-			}
-
-			if err := c.api.Session(r, options); err != nil {
-				slog.Error("Error extending session", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				break
-			}
+	data, err := readHitData(w, r)
+
+	if err != nil {
+		w.WriteHeader(getBodyErrorStatus(err))
+		return
+	}
+
+	hit := newHit(sessionHit, r)
+	hit.Options.URL = r.URL.Query().Get("url")
+
+	token := r.URL.Query().Get("token")
+
+	// the page URL can optionally be sent in the body, like for events
+	if data != nil {
+		data.apply(hit)
+
+		if data.Token != "" {
+			token = data.Token
 		}
 	}
+
+	if err := hit.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := verifyToken(hit, getToken(r, token)); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	deliver(w, hit)
+}
+
+func getPageViewHit(r *http.Request) *Hit {
+	query := r.URL.Query()
+	width, _ := strconv.ParseInt(query.Get("w"), 10, 16)
+	height, _ := strconv.ParseInt(query.Get("h"), 10, 16)
+	hit := newHit(pageViewHit, r)
+	hit.Options.URL = query.Get("url")
+	hit.Options.Title = query.Get("t")
+	hit.Options.Referrer = query.Get("ref")
+	hit.Options.ScreenWidth = int(width)
+	hit.Options.ScreenHeight = int(height)
+	hit.Options.Tags = getTags(query)
+	return hit
+}
+
+// deliver sends the hit to all clients accepting it and sets the response status according to the policy.
+func deliver(w http.ResponseWriter, hit *Hit) {
+	if status := getResponseStatus(fanOut(hit), config.Policy); status != http.StatusOK {
+		w.WriteHeader(status)
+	}
+}
+
+// getResponseStatus returns the response status for the delivery results.
+// The policy "all" requires all deliveries to succeed, "any" requires at least one.
+func getResponseStatus(results []deliveryResult, policy string) int {
+	status := http.StatusOK
+	failed := 0
+
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+		} else if result.queued {
+			status = http.StatusAccepted
+		}
+	}
+
+	if failed == 0 || (policy == policyAny && failed < len(results)) {
+		return status
+	}
+
+	for _, result := range results {
+		if errors.Is(result.err, errQueueFull) {
+			return http.StatusServiceUnavailable
+		}
+	}
+
+	return http.StatusInternalServerError
 }
 
-func acceptRequest(client client, r *http.Request) bool {
+func acceptRequest(client client, hit *Hit) bool {
 	for _, f := range client.filter {
-		if !f(r.URL) {
+		if !f(hit) {
 			return false
 		}
 	}
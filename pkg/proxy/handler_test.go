@@ -1,15 +1,318 @@
 package proxy
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	pirsch "github.com/pirsch-analytics/pirsch-go-sdk/v2/pkg"
 	"github.com/stretchr/testify/assert"
 )
 
+// apiMock records all requests sent to the Pirsch API.
+// Page views and session extensions are decoded as events with an empty name.
+// Hits for the reject URL are answered with a bad request.
+type apiMock struct {
+	hits   []pirsch.Event
+	status int
+	reject string
+	m      sync.Mutex
+}
+
+func newAPIMock(t *testing.T) (*apiMock, *httptest.Server) {
+	mock := &apiMock{status: http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hit pirsch.Event
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&hit))
+		mock.m.Lock()
+		defer mock.m.Unlock()
+
+		if mock.reject != "" && hit.URL == mock.reject {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if mock.status == http.StatusOK {
+			mock.hits = append(mock.hits, hit)
+		}
+
+		w.WriteHeader(mock.status)
+	}))
+	t.Cleanup(server.Close)
+	return mock, server
+}
+
+func (mock *apiMock) setStatus(status int) {
+	mock.m.Lock()
+	defer mock.m.Unlock()
+	mock.status = status
+}
+
+func (mock *apiMock) received() []pirsch.Event {
+	mock.m.Lock()
+	defer mock.m.Unlock()
+	return append([]pirsch.Event{}, mock.hits...)
+}
+
+func setTestClients(t *testing.T, c ...client) {
+	clients = c
+	config = &Config{Server: Server{MaxBodySize: 64}, Policy: policyAll}
+	t.Cleanup(func() {
+		clients = nil
+		config = nil
+	})
+}
+
+func newTestClient(name string, server *httptest.Server, filter ...FilterFunc) client {
+	return client{
+		name:   name,
+		api:    pirsch.NewClient("", "secret", &pirsch.ClientConfig{BaseURL: server.URL}),
+		filter: filter,
+	}
+}
+
+func TestPageView(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	req := httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo&t=Foo&w=1920&h=1080", nil)
+	req.Header.Set("User-Agent", "ua")
+	w := httptest.NewRecorder()
+	pageView(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	hits := mock.received()
+	assert.Len(t, hits, 1)
+	assert.Equal(t, "https://example.com/foo", hits[0].URL)
+	assert.Equal(t, "Foo", hits[0].Title)
+	assert.Equal(t, "ua", hits[0].UserAgent)
+	assert.Equal(t, 1920, hits[0].ScreenWidth)
+	req = httptest.NewRequest(http.MethodGet, "/p/pv?t=Foo", nil)
+	w = httptest.NewRecorder()
+	pageView(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, mock.received(), 1)
+}
+
+func TestEvent(t *testing.T) {
+	mock, server := newAPIMock(t)
+	otherMock, otherServer := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server, NewHostnameFilter([]string{"example.com"})),
+		newTestClient("other", otherServer, NewHostnameFilter([]string{"other.com"})))
+	req := httptest.NewRequest(http.MethodPost, "/p/e", strings.NewReader(`{"url": "https://example.com/foo", "event_name": "Signup", "event_meta": {"plan": "pro"}}`))
+	w := httptest.NewRecorder()
+	event(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, mock.received(), 1)
+	assert.Equal(t, "https://example.com/foo", mock.received()[0].URL)
+	assert.Equal(t, "Signup", mock.received()[0].Name)
+	assert.Equal(t, map[string]string{"plan": "pro"}, mock.received()[0].Metadata)
+	assert.Empty(t, otherMock.received())
+	req = httptest.NewRequest(http.MethodPost, "/p/e", strings.NewReader(`{"url": "https://example.com/foo"}`))
+	w = httptest.NewRecorder()
+	event(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, mock.received(), 1)
+}
+
+func TestSession(t *testing.T) {
+	mock, server := newAPIMock(t)
+	otherMock, otherServer := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server, NewHostnameFilter([]string{"example.com"})),
+		newTestClient("other", otherServer, NewHostnameFilter([]string{"other.com"})))
+	req := httptest.NewRequest(http.MethodPost, "/p/s?url=https://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	session(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, mock.received(), 1)
+	assert.Equal(t, "https://example.com/foo", mock.received()[0].URL)
+	req = httptest.NewRequest(http.MethodPost, "/p/s", strings.NewReader(`{"url": "https://other.com/bar"}`))
+	w = httptest.NewRecorder()
+	session(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, mock.received(), 1)
+	assert.Len(t, otherMock.received(), 1)
+	assert.Equal(t, "https://other.com/bar", otherMock.received()[0].URL)
+	req = httptest.NewRequest(http.MethodPost, "/p/s", strings.NewReader(`invalid`))
+	w = httptest.NewRecorder()
+	session(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	req = httptest.NewRequest(http.MethodPost, "/p/s?url=/foo", nil)
+	w = httptest.NewRecorder()
+	session(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, mock.received(), 1)
+	assert.Len(t, otherMock.received(), 1)
+}
+
+func TestPageViewQueue(t *testing.T) {
+	mock, server := newAPIMock(t)
+	mock.setStatus(http.StatusInternalServerError)
+	q, err := newQueue(t.TempDir(), 1024*1024, 1024)
+	assert.NoError(t, err)
+	c := newTestClient("test", server)
+	c.queue = q
+	c.done = make(chan struct{})
+	setTestClients(t, c)
+	workers.Add(1)
+	go c.drain(time.Hour, time.Millisecond*10)
+	req := httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	pageView(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	time.Sleep(time.Millisecond * 50)
+	assert.Empty(t, mock.received())
+	mock.setStatus(http.StatusOK)
+	assert.Eventually(t, func() bool {
+		return len(mock.received()) == 1
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, "https://example.com/foo", mock.received()[0].URL)
+	StopClients()
+}
+
+func TestPageViewQueueRejected(t *testing.T) {
+	mock, server := newAPIMock(t)
+	mock.reject = "https://example.com/rejected"
+	q, err := newQueue(t.TempDir(), 1024*1024, 1024)
+	assert.NoError(t, err)
+	c := newTestClient("test", server)
+	c.queue = q
+	c.done = make(chan struct{})
+	setTestClients(t, c)
+	assert.NoError(t, q.push(&Hit{Kind: pageViewHit, Time: time.Now(), Options: pirsch.PageViewOptions{URL: "https://example.com/rejected"}}))
+	assert.NoError(t, q.push(&Hit{Kind: pageViewHit, Time: time.Now(), Options: pirsch.PageViewOptions{URL: "https://example.com/foo"}}))
+	workers.Add(1)
+	go c.drain(time.Hour, time.Millisecond*10)
+	assert.Eventually(t, func() bool {
+		return len(mock.received()) == 1
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, "https://example.com/foo", mock.received()[0].URL)
+	StopClients()
+}
+
+func TestIsPermanentError(t *testing.T) {
+	assert.True(t, isPermanentError(errors.New("https://api.pirsch.io/api/v1/hit: received status code 400 on request: invalid url")))
+	assert.True(t, isPermanentError(errors.New("https://api.pirsch.io/api/v1/hit: received status code 404 on request")))
+	assert.False(t, isPermanentError(errors.New("https://api.pirsch.io/api/v1/hit: received status code 401 on request")))
+	assert.False(t, isPermanentError(errors.New("https://api.pirsch.io/api/v1/hit: received status code 408 on request")))
+	assert.False(t, isPermanentError(errors.New("https://api.pirsch.io/api/v1/hit: received status code 429 on request")))
+	assert.False(t, isPermanentError(errors.New("https://api.pirsch.io/api/v1/hit: received status code 502 on request")))
+	assert.False(t, isPermanentError(errors.New("error refreshing token (attempt 1/5): https://api.pirsch.io/api/v1/token: received status code 400 on request")))
+	assert.False(t, isPermanentError(errors.New("dial tcp: connection refused")))
+	assert.False(t, isPermanentError(errCircuitOpen))
+}
+
+func TestGetStatusCode(t *testing.T) {
+	// the status code is parsed from the error message, so make sure it matches the errors returned by the SDK
+	for _, code := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusTooManyRequests, http.StatusBadGateway} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		c := pirsch.NewClient("", "secret", &pirsch.ClientConfig{BaseURL: server.URL})
+		err := c.PageView(httptest.NewRequest(http.MethodGet, "/", nil), &pirsch.PageViewOptions{URL: "https://example.com/"})
+		assert.Error(t, err)
+		assert.Equal(t, code, getStatusCode(err))
+		server.Close()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	c := pirsch.NewClient("id", "secret", &pirsch.ClientConfig{BaseURL: server.URL, RequestRetries: 1})
+	err := c.PageView(httptest.NewRequest(http.MethodGet, "/", nil), &pirsch.PageViewOptions{URL: "https://example.com/"})
+	assert.Error(t, err)
+	assert.Equal(t, 0, getStatusCode(err))
+}
+
+func TestPageViewBreakerRejected(t *testing.T) {
+	mock, server := newAPIMock(t)
+	mock.reject = "https://example.com/rejected"
+	c := newTestClient("test", server)
+	c.breaker = newBreaker("test", 2, time.Minute)
+	setTestClients(t, c)
+
+	for range 5 {
+		w := httptest.NewRecorder()
+		pageView(w, httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/rejected", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	}
+
+	assert.Equal(t, breakerClosed, c.breaker.status().State)
+	w := httptest.NewRecorder()
+	pageView(w, httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, mock.received(), 1)
+	mock.setStatus(http.StatusBadGateway)
+
+	for range 2 {
+		w = httptest.NewRecorder()
+		pageView(w, httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo", nil))
+	}
+
+	assert.Equal(t, breakerOpen, c.breaker.status().State)
+}
+
+func TestIsBreakerFailure(t *testing.T) {
+	assert.False(t, isBreakerFailure(nil))
+	assert.False(t, isBreakerFailure(errors.New("https://api.pirsch.io/api/v1/hit: received status code 400 on request")))
+	assert.False(t, isBreakerFailure(errors.New("https://api.pirsch.io/api/v1/hit: received status code 404 on request")))
+	assert.True(t, isBreakerFailure(errors.New("https://api.pirsch.io/api/v1/hit: received status code 401 on request")))
+	assert.True(t, isBreakerFailure(errors.New("https://api.pirsch.io/api/v1/hit: received status code 429 on request")))
+	assert.True(t, isBreakerFailure(errors.New("https://api.pirsch.io/api/v1/hit: received status code 503 on request")))
+	assert.True(t, isBreakerFailure(errors.New("dial tcp: connection refused")))
+}
+
+func TestPageViewTags(t *testing.T) {
+	mock, server := newAPIMock(t)
+	filteredMock, filteredServer := newAPIMock(t)
+	c := newTestClient("filtered", filteredServer)
+	c.tags = ClientTags{Allowed: []string{"author"}, MaxLength: 3}
+	setTestClients(t, newTestClient("test", server), c)
+	req := httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo&tag_author=John&tag_category=Go", nil)
+	w := httptest.NewRecorder()
+	pageView(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]string{"author": "John", "category": "Go"}, mock.received()[0].Tags)
+	assert.Equal(t, map[string]string{"author": "Joh"}, filteredMock.received()[0].Tags)
+}
+
+func TestPageViewFanOut(t *testing.T) {
+	mock, server := newAPIMock(t)
+	failingMock, failingServer := newAPIMock(t)
+	failingMock.setStatus(http.StatusUnauthorized)
+	setTestClients(t, newTestClient("failing", failingServer), newTestClient("test", server))
+	req := httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	pageView(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Len(t, mock.received(), 1)
+	config.Policy = policyAny
+	w = httptest.NewRecorder()
+	pageView(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, mock.received(), 2)
+}
+
+func TestGetResponseStatus(t *testing.T) {
+	failed := deliveryResult{err: errors.New("failed")}
+	full := deliveryResult{queued: true, err: errQueueFull}
+	sent := deliveryResult{}
+	queued := deliveryResult{queued: true}
+	assert.Equal(t, http.StatusOK, getResponseStatus(nil, policyAll))
+	assert.Equal(t, http.StatusOK, getResponseStatus([]deliveryResult{sent, sent}, policyAll))
+	assert.Equal(t, http.StatusAccepted, getResponseStatus([]deliveryResult{sent, queued}, policyAll))
+	assert.Equal(t, http.StatusInternalServerError, getResponseStatus([]deliveryResult{sent, failed}, policyAll))
+	assert.Equal(t, http.StatusOK, getResponseStatus([]deliveryResult{sent, failed}, policyAny))
+	assert.Equal(t, http.StatusInternalServerError, getResponseStatus([]deliveryResult{failed, failed}, policyAny))
+	assert.Equal(t, http.StatusServiceUnavailable, getResponseStatus([]deliveryResult{full}, policyAny))
+}
+
 func TestAcceptRequest(t *testing.T) {
-	req := httptest.NewRequest(http.MethodGet, "https://proxy.com/hit?url=https://example.com/foo/bar&code=asdf1234", nil)
+	req := getTestHit("https://proxy.com/hit?url=https://example.com/foo/bar&code=asdf1234")
 	assert.True(t, acceptRequest(client{
 		filter: []FilterFunc{},
 	}, req))
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	pirsch "github.com/pirsch-analytics/pirsch-go-sdk/v2/pkg"
+)
+
+const (
+	pageViewHit = "page_view"
+	eventHit    = "event"
+	sessionHit  = "session"
+)
+
+var (
+	errHitURL       = errors.New("url invalid")
+	errHitEventName = errors.New("event name missing")
+)
+
+// Hit is a page view, event, or session extension that is sent to the clients.
+// It holds all data required to send the request to Pirsch, so that it can be stored and delivered later on.
+// Each handler builds the hit from the request, so that client filters can be applied the same way for all endpoints.
+type Hit struct {
+	Kind          string                 `json:"kind"`
+	Time          time.Time              `json:"time"`
+	Code          string                 `json:"code,omitempty"`
+	Options       pirsch.PageViewOptions `json:"options"`
+	EventName     string                 `json:"event_name,omitempty"`
+	EventDuration int                    `json:"event_duration,omitempty"`
+	EventMeta     map[string]string      `json:"event_meta,omitempty"`
+
+	// ServerSide is set for hits not sent by a browser, which don't necessarily pass the headers browsers send.
+	ServerSide bool `json:"-"`
+}
+
+// hitData is the JSON representation of a hit sent to the event, session, and batch endpoints.
+type hitData struct {
+	Code          string            `json:"identification_code"`
+	URL           string            `json:"url"`
+	Title         string            `json:"title"`
+	Referrer      string            `json:"referrer"`
+	ScreenWidth   int               `json:"screen_width"`
+	ScreenHeight  int               `json:"screen_height"`
+	EventName     string            `json:"event_name"`
+	EventDuration int               `json:"event_duration"`
+	EventMeta     map[string]string `json:"event_meta"`
+	Tags          map[string]string `json:"tags"`
+	Token         string            `json:"token"`
+}
+
+func newHit(kind string, r *http.Request) *Hit {
+	return &Hit{
+		Kind: kind,
+		Time: time.Now().UTC(),
+		Code: r.URL.Query().Get("code"),
+		Options: pirsch.PageViewOptions{
+			IP:                     getIP(r),
+			UserAgent:              r.Header.Get("User-Agent"),
+			AcceptLanguage:         r.Header.Get("Accept-Language"),
+			SecCHUA:                r.Header.Get("Sec-CH-UA"),
+			SecCHUAMobile:          r.Header.Get("Sec-CH-UA-Mobile"),
+			SecCHUAPlatform:        r.Header.Get("Sec-CH-UA-Platform"),
+			SecCHUAPlatformVersion: r.Header.Get("Sec-CH-UA-Platform-Version"),
+			SecCHWidth:             r.Header.Get("Sec-CH-Width"),
+			SecCHViewportWidth:     r.Header.Get("Sec-CH-Viewport-Width"),
+		},
+	}
+}
+
+// apply sets all fields present on the hit.
+func (data *hitData) apply(hit *Hit) {
+	if data.Code != "" {
+		hit.Code = data.Code
+	}
+
+	if data.URL != "" {
+		hit.Options.URL = data.URL
+	}
+
+	hit.Options.Title = data.Title
+	hit.Options.Referrer = data.Referrer
+	hit.Options.ScreenWidth = data.ScreenWidth
+	hit.Options.ScreenHeight = data.ScreenHeight
+	hit.Options.Tags = data.Tags
+
+	if hit.Kind == eventHit {
+		hit.EventName = data.EventName
+		hit.EventDuration = data.EventDuration
+		hit.EventMeta = data.EventMeta
+	}
+}
+
+// validate checks that the hit has an absolute page URL and events have a name.
+func (hit *Hit) validate() error {
+	u := hit.PageURL()
+
+	if u == nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errHitURL
+	}
+
+	if hit.Kind == eventHit && hit.EventName == "" {
+		return errHitEventName
+	}
+
+	return nil
+}
+
+// PageURL returns the parsed page URL or nil if it is invalid.
+func (hit *Hit) PageURL() *url.URL {
+	u, err := url.Parse(hit.Options.URL)
+
+	if err != nil {
+		return nil
+	}
+
+	return u
+}
+
+// Hostname returns the lowercase hostname of the page URL.
+func (hit *Hit) Hostname() string {
+	if u := hit.PageURL(); u != nil {
+		return strings.ToLower(u.Hostname())
+	}
+
+	return ""
+}
+
+// Path returns the lowercase path of the page URL.
+func (hit *Hit) Path() string {
+	if u := hit.PageURL(); u != nil {
+		return strings.ToLower(u.Path)
+	}
+
+	return ""
+}
+
+// request creates a synthetic request for the SDK.
+// All data is passed using the options, so the request only needs to carry the page URL.
+func (hit *Hit) request() *http.Request {
+	u := hit.PageURL()
+
+	if u == nil {
+		u = new(url.URL)
+	}
+
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    u,
+		Header: make(http.Header),
+	}
+}
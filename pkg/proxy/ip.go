@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"strings"
@@ -75,8 +76,12 @@ func validProxySource(address string, allowed []net.IPNet) bool {
 		return false
 	}
 
-	for _, from := range allowed {
-		if from.Contains(ip) {
+	return containsIP(allowed, ip)
+}
+
+func containsIP(subnets []net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
 			return true
 		}
 	}
@@ -84,6 +89,44 @@ func validProxySource(address string, allowed []net.IPNet) bool {
 	return false
 }
 
+// parseSubnet parses an IP or subnet in CIDR notation.
+// A single IP is converted to a subnet containing only that IP.
+func parseSubnet(subnet string) (*net.IPNet, error) {
+	if !strings.Contains(subnet, "/") {
+		ip := net.ParseIP(subnet)
+
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %s", subnet)
+		}
+
+		if ip.To4() != nil {
+			subnet += "/32"
+		} else {
+			subnet += "/128"
+		}
+	}
+
+	_, n, err := net.ParseCIDR(subnet)
+	return n, err
+}
+
+// parseSubnets parses a list of IPs and subnets in CIDR notation.
+func parseSubnets(subnets []string) ([]net.IPNet, error) {
+	result := make([]net.IPNet, 0, len(subnets))
+
+	for _, subnet := range subnets {
+		n, err := parseSubnet(subnet)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, *n)
+	}
+
+	return result, nil
+}
+
 func parseForwardedHeader(value string) string {
 	parts := strings.Split(value, ",")
 
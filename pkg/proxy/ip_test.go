@@ -186,3 +186,16 @@ func TestIsValidIP(t *testing.T) {
 	assert.False(t, isValidIP("0.0.0.0"))
 	assert.True(t, isValidIP("1.2.3.4"))
 }
+
+func TestParseSubnets(t *testing.T) {
+	subnets, err := parseSubnets([]string{"10.0.0.0/8", "203.0.113.7", "2001:db8::1"})
+	assert.NoError(t, err)
+	assert.Len(t, subnets, 3)
+	assert.Equal(t, "10.0.0.0/8", subnets[0].String())
+	assert.Equal(t, "203.0.113.7/32", subnets[1].String())
+	assert.Equal(t, "2001:db8::1/128", subnets[2].String())
+	_, err = parseSubnets([]string{"invalid"})
+	assert.Error(t, err)
+	_, err = parseSubnets([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	globalIPFilter *ipFilter
+)
+
+// ipFilter drops hits by the visitor IP.
+// If an allow list is configured, only IPs on it are accepted, even if it has no entries (for example, because a file is empty).
+// IPs on the block list are always dropped.
+type ipFilter struct {
+	allow *ipList
+	block *ipList
+}
+
+// ipList is a list of subnets configured inline and loaded from files.
+// Files are reloaded when they change.
+type ipList struct {
+	inline []net.IPNet
+	files  [][]net.IPNet
+	m      sync.RWMutex
+}
+
+// SetupIPFilter initializes the global IP block and allow lists.
+func SetupIPFilter() {
+	globalIPFilter = newIPFilter(config.IPFilter)
+}
+
+// newIPFilter creates the filter for the configuration or returns nil if nothing is configured.
+// Files are checked for changes in the configured reload interval, falling back to the global one.
+func newIPFilter(cfg IPFilter) *ipFilter {
+	if len(cfg.Allow) == 0 && len(cfg.AllowFiles) == 0 && len(cfg.Block) == 0 && len(cfg.BlockFiles) == 0 {
+		return nil
+	}
+
+	reloadInterval := cfg.ReloadInterval
+
+	if reloadInterval <= 0 {
+		reloadInterval = config.IPFilter.ReloadInterval
+	}
+
+	interval := time.Duration(reloadInterval) * time.Second
+	allow, err := newIPList(cfg.Allow, cfg.AllowFiles, interval)
+
+	if err != nil {
+		slog.Error("Error loading IP allow list", "err", err)
+		panic(err)
+	}
+
+	block, err := newIPList(cfg.Block, cfg.BlockFiles, interval)
+
+	if err != nil {
+		slog.Error("Error loading IP block list", "err", err)
+		panic(err)
+	}
+
+	return &ipFilter{allow: allow, block: block}
+}
+
+// accept returns whether hits from the IP are accepted.
+func (f *ipFilter) accept(address string) bool {
+	ip := net.ParseIP(address)
+
+	if ip == nil {
+		return !f.allow.configured()
+	}
+
+	if f.allow.configured() && !f.allow.contains(ip) {
+		return false
+	}
+
+	return !f.block.contains(ip)
+}
+
+func newIPList(inline, files []string, interval time.Duration) (*ipList, error) {
+	subnets, err := parseSubnets(inline)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &ipList{
+		inline: subnets,
+		files:  make([][]net.IPNet, len(files)),
+	}
+
+	for i, path := range files {
+		err := watchFile(path, interval, func(data []byte) error {
+			subnets, err := parseSubnetFile(data)
+
+			if err != nil {
+				return err
+			}
+
+			l.m.Lock()
+			defer l.m.Unlock()
+			l.files[i] = subnets
+			return nil
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s: %w", path, err)
+		}
+	}
+
+	return l, nil
+}
+
+func (l *ipList) contains(ip net.IP) bool {
+	if containsIP(l.inline, ip) {
+		return true
+	}
+
+	l.m.RLock()
+	defer l.m.RUnlock()
+
+	for _, subnets := range l.files {
+		if containsIP(subnets, ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configured returns whether any entries or files have been configured, regardless of whether they contain entries.
+func (l *ipList) configured() bool {
+	return len(l.inline) > 0 || len(l.files) > 0
+}
+
+// parseSubnetFile parses a file containing one IP or subnet in CIDR notation per line.
+// Empty lines and comments starting with # are ignored.
+func parseSubnetFile(data []byte) ([]net.IPNet, error) {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return parseSubnets(lines)
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSubnetFile(t *testing.T) {
+	subnets, err := parseSubnetFile([]byte("# office\n192.168.0.0/16 # VPN\n\n198.51.100.1\n"))
+	assert.NoError(t, err)
+	assert.Len(t, subnets, 2)
+}
+
+func TestIPFilter(t *testing.T) {
+	setTestClients(t)
+	assert.Nil(t, newIPFilter(IPFilter{}))
+	f := newIPFilter(IPFilter{Block: []string{"10.0.0.0/8"}})
+	assert.True(t, f.accept("203.0.113.7"))
+	assert.False(t, f.accept("10.1.2.3"))
+	assert.True(t, f.accept(""))
+	f = newIPFilter(IPFilter{Allow: []string{"10.0.0.0/8"}, Block: []string{"10.1.0.0/16"}})
+	assert.True(t, f.accept("10.2.3.4"))
+	assert.False(t, f.accept("10.1.2.3"))
+	assert.False(t, f.accept("203.0.113.7"))
+	assert.False(t, f.accept(""))
+	assert.Panics(t, func() {
+		newIPFilter(IPFilter{Block: []string{"invalid"}})
+	})
+}
+
+func TestIPFilterFile(t *testing.T) {
+	setTestClients(t)
+	config.IPFilter.ReloadInterval = 1
+	path := filepath.Join(t.TempDir(), "block.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("203.0.113.0/24\n"), 0600))
+	f := newIPFilter(IPFilter{BlockFiles: []string{path}})
+	assert.False(t, f.accept("203.0.113.7"))
+	assert.True(t, f.accept("198.51.100.1"))
+	assert.NoError(t, os.WriteFile(path, []byte("198.51.100.0/24 # changed\n"), 0600))
+	assert.Eventually(t, func() bool {
+		return f.accept("203.0.113.7") && !f.accept("198.51.100.1")
+	}, time.Second*3, time.Millisecond*100)
+	assert.Panics(t, func() {
+		newIPFilter(IPFilter{AllowFiles: []string{filepath.Join(t.TempDir(), "missing.txt")}})
+	})
+}
+
+func TestIPFilterReloadInterval(t *testing.T) {
+	setTestClients(t)
+	t.Cleanup(stopWatchers)
+	config.IPFilter.ReloadInterval = 3600
+	path := filepath.Join(t.TempDir(), "block.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("203.0.113.0/24\n"), 0600))
+
+	// the interval configured for the client takes precedence over the global one
+	f := newIPFilter(IPFilter{BlockFiles: []string{path}, ReloadInterval: 1})
+	assert.False(t, f.accept("203.0.113.7"))
+	assert.NoError(t, os.WriteFile(path, []byte("198.51.100.0/24\n"), 0600))
+	assert.Eventually(t, func() bool {
+		return f.accept("203.0.113.7")
+	}, time.Second*3, time.Millisecond*100)
+}
+
+func TestIPFilterEmptyAllowFile(t *testing.T) {
+	setTestClients(t)
+	config.IPFilter.ReloadInterval = 1
+	path := filepath.Join(t.TempDir(), "allow.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("203.0.113.0/24\n"), 0600))
+	f := newIPFilter(IPFilter{AllowFiles: []string{path}})
+	assert.True(t, f.accept("203.0.113.7"))
+	assert.False(t, f.accept("198.51.100.1"))
+
+	// a truncated allow list rejects all hits instead of accepting them
+	assert.NoError(t, os.WriteFile(path, []byte("# empty\n"), 0600))
+	assert.Eventually(t, func() bool {
+		return !f.accept("203.0.113.7")
+	}, time.Second*3, time.Millisecond*100)
+	assert.False(t, f.accept("198.51.100.1"))
+	assert.False(t, f.accept(""))
+}
+
+func TestPageViewIPFilter(t *testing.T) {
+	mock, server := newAPIMock(t)
+	otherMock, otherServer := newAPIMock(t)
+	setTestClients(t, newTestClient("filtered", server), newTestClient("unfiltered", otherServer))
+	clients[0].ipFilter = newIPFilter(IPFilter{Block: []string{"198.51.100.0/24"}})
+	globalIPFilter = newIPFilter(IPFilter{Block: []string{"203.0.113.0/24"}})
+	t.Cleanup(func() {
+		globalIPFilter = nil
+	})
+
+	for _, ip := range []string{"192.0.2.1", "198.51.100.1", "203.0.113.1"} {
+		req := httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		pageView(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	assert.Len(t, mock.received(), 1)
+	assert.Equal(t, "192.0.2.1", mock.received()[0].IP)
+	assert.Len(t, otherMock.received(), 2)
+}
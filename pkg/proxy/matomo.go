@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	errMatomoSite = errors.New("site invalid")
+)
+
+// matomoBulkRequest is a Matomo bulk tracking request.
+// Each request is a query string like "?idsite=1&rec=1&url=...".
+type matomoBulkRequest struct {
+	Requests []string `json:"requests"`
+}
+
+// matomoBulkResponse is the response to a Matomo bulk tracking request.
+type matomoBulkResponse struct {
+	Status         string `json:"status"`
+	Tracked        int    `json:"tracked"`
+	Invalid        int    `json:"invalid"`
+	InvalidIndices []int  `json:"invalid_indices"`
+}
+
+// matomo translates Matomo tracking requests to page views, events, and session extensions.
+// Requests can be sent as query parameters, form encoded body, or as a JSON bulk request.
+// Single requests are answered with a transparent GIF like Matomo does, unless send_image=0 is passed.
+func matomo(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(w, r)
+
+	if err != nil {
+		w.WriteHeader(getBodyErrorStatus(err))
+		return
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		matomoBulk(w, r, body)
+		return
+	}
+
+	values := r.URL.Query()
+
+	if len(body) > 0 {
+		form, err := url.ParseQuery(string(body))
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		for key, v := range form {
+			values[key] = v
+		}
+	}
+
+	if values.Get("rec") == "1" {
+		hit, names, err := getMatomoHit(r, values)
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fanOutTo(hit, getClientsByName(names))
+	}
+
+	if values.Get("send_image") == "0" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writePixel(w)
+}
+
+func matomoBulk(w http.ResponseWriter, r *http.Request, body []byte) {
+	var bulk matomoBulkRequest
+
+	if err := json.Unmarshal(body, &bulk); err != nil || len(bulk.Requests) > config.Batch.MaxItems {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp := matomoBulkResponse{Status: "success", InvalidIndices: make([]int, 0)}
+
+	for i, req := range bulk.Requests {
+		values, err := url.ParseQuery(strings.TrimPrefix(req, "?"))
+
+		if err != nil || values.Get("rec") != "1" {
+			resp.Invalid++
+			resp.InvalidIndices = append(resp.InvalidIndices, i)
+			continue
+		}
+
+		hit, names, err := getMatomoHit(r, values)
+
+		if err != nil {
+			resp.Invalid++
+			resp.InvalidIndices = append(resp.InvalidIndices, i)
+			continue
+		}
+
+		resp.Tracked++
+		fanOutTo(hit, getClientsByName(names))
+	}
+
+	writeJSON(w, resp)
+}
+
+// getMatomoHit creates a hit for the tracking request and returns the names of the clients to send it to.
+// Events (e_c and e_a) are sent as events, heartbeats (ping=1) as session extensions, outbound links and downloads
+// as events using the names configured for the redirect endpoint, and everything else as page views.
+func getMatomoHit(r *http.Request, values url.Values) (*Hit, []string, error) {
+	var names []string
+
+	if len(config.Matomo.Sites) > 0 {
+		var found bool
+		names, found = config.Matomo.Sites[values.Get("idsite")]
+
+		if !found {
+			return nil, nil, errMatomoSite
+		}
+	}
+
+	kind := pageViewHit
+	category, action := values.Get("e_c"), values.Get("e_a")
+
+	if (category != "" && action != "") || values.Get("link") != "" || values.Get("download") != "" {
+		kind = eventHit
+	} else if values.Get("ping") == "1" {
+		kind = sessionHit
+	}
+
+	hit := newHit(kind, r)
+	hit.Options.URL = values.Get("url")
+	hit.Options.Title = values.Get("action_name")
+	hit.Options.Referrer = values.Get("urlref")
+
+	if width, height, found := strings.Cut(values.Get("res"), "x"); found {
+		hit.Options.ScreenWidth, _ = strconv.Atoi(width)
+		hit.Options.ScreenHeight, _ = strconv.Atoi(height)
+	}
+
+	if ua := values.Get("ua"); ua != "" {
+		hit.Options.UserAgent = ua
+	}
+
+	if lang := values.Get("lang"); lang != "" {
+		hit.Options.AcceptLanguage = lang
+	}
+
+	if kind == eventHit {
+		if link := values.Get("link"); link != "" {
+			hit.EventName = config.Redirect.EventName
+			hit.EventMeta = map[string]string{"url": link}
+		} else if download := values.Get("download"); download != "" {
+			hit.EventName = config.Redirect.DownloadEventName
+			hit.EventMeta = map[string]string{"url": download}
+		} else {
+			hit.EventName = action
+			hit.EventMeta = map[string]string{"category": category}
+
+			if name := values.Get("e_n"); name != "" {
+				hit.EventMeta["name"] = name
+			}
+
+			if value := values.Get("e_v"); value != "" {
+				hit.EventMeta["value"] = value
+			}
+		}
+	}
+
+	if err := hit.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return hit, names, nil
+}
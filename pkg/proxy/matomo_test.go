@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatomo(t *testing.T) {
+	mock, server := newAPIMock(t)
+	otherMock, otherServer := newAPIMock(t)
+	setTestClients(t, newTestClient("blog", server), newTestClient("shop", otherServer))
+	config.Matomo.Sites = map[string][]string{"1": {"blog"}, "2": {"shop"}}
+	config.Redirect.EventName = "Outbound Link"
+	req := httptest.NewRequest(http.MethodGet, "/matomo.php?idsite=1&rec=1&url=https%3A%2F%2Fexample.com%2Ffoo&action_name=Foo&urlref=https%3A%2F%2Fgoogle.com&res=1920x1080&ua=Mozilla%2F5.0", nil)
+	w := httptest.NewRecorder()
+	matomo(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/gif", w.Header().Get("Content-Type"))
+	req = httptest.NewRequest(http.MethodPost, "/matomo.php", strings.NewReader("idsite=1&rec=1&url=https%3A%2F%2Fexample.com%2Ffoo&e_c=Videos&e_a=Play&e_n=Intro&e_v=3&send_image=0"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	matomo(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	req = httptest.NewRequest(http.MethodGet, "/matomo.php?idsite=2&rec=1&url=https%3A%2F%2Fshop.com%2F&link=https%3A%2F%2Fgithub.com", nil)
+	w = httptest.NewRecorder()
+	matomo(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	hits := mock.received()
+	assert.Len(t, hits, 2)
+	assert.Empty(t, hits[0].Name)
+	assert.Equal(t, "https://example.com/foo", hits[0].URL)
+	assert.Equal(t, "Foo", hits[0].Title)
+	assert.Equal(t, "https://google.com", hits[0].Referrer)
+	assert.Equal(t, 1920, hits[0].ScreenWidth)
+	assert.Equal(t, 1080, hits[0].ScreenHeight)
+	assert.Equal(t, "Mozilla/5.0", hits[0].UserAgent)
+	assert.Equal(t, "Play", hits[1].Name)
+	assert.Equal(t, map[string]string{"category": "Videos", "name": "Intro", "value": "3"}, hits[1].Metadata)
+	otherHits := otherMock.received()
+	assert.Len(t, otherHits, 1)
+	assert.Equal(t, "Outbound Link", otherHits[0].Name)
+	assert.Equal(t, map[string]string{"url": "https://github.com"}, otherHits[0].Metadata)
+}
+
+func TestMatomoBulk(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("blog", server))
+	config.Batch.MaxItems = 10
+	req := httptest.NewRequest(http.MethodPost, "/matomo.php", strings.NewReader(`{"requests": [
+		"?idsite=1&rec=1&url=https%3A%2F%2Fexample.com%2Ffoo",
+		"?idsite=1&rec=1&url=https%3A%2F%2Fexample.com%2Ffoo&ping=1",
+		"?idsite=1&url=https%3A%2F%2Fexample.com%2Ffoo",
+		"?idsite=1&rec=1&url=%2Ffoo"
+	]}`))
+	w := httptest.NewRecorder()
+	matomo(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp matomoBulkResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, matomoBulkResponse{Status: "success", Tracked: 2, Invalid: 2, InvalidIndices: []int{2, 3}}, resp)
+	assert.Len(t, mock.received(), 2)
+}
+
+func TestMatomoInvalid(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("blog", server))
+	config.Matomo.Sites = map[string][]string{"1": {"blog"}}
+
+	for _, query := range []string{
+		"idsite=2&rec=1&url=https%3A%2F%2Fexample.com",
+		"idsite=1&rec=1",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/matomo.php?"+query, nil)
+		w := httptest.NewRecorder()
+		matomo(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+
+	assert.Empty(t, mock.received())
+}
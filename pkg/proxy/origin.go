@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	origins *originFilter
+)
+
+// originFilter checks the Origin and Referer headers of requests against the allowed hostnames.
+type originFilter struct {
+	directMatch []string
+	regexMatch  []regexp.Regexp
+	rejected    atomic.Uint64
+}
+
+func newOriginFilter(allowed []string) *originFilter {
+	directMatch, regexMatch := getMatchers(allowed)
+	return &originFilter{
+		directMatch: directMatch,
+		regexMatch:  regexMatch,
+	}
+}
+
+// allowOrigin returns whether the origin (or any other URL) belongs to an allowed hostname.
+// It's used for CORS and the server-side check.
+func (f *originFilter) allowOrigin(_ *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+
+	if err != nil {
+		return false
+	}
+
+	hostname := strings.ToLower(u.Hostname())
+
+	if hostname == "" {
+		return false
+	}
+
+	for _, match := range f.directMatch {
+		if hostname == match {
+			return true
+		}
+	}
+
+	for _, match := range f.regexMatch {
+		if match.MatchString(hostname) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check is a middleware rejecting requests whose Origin (or Referer if not set) doesn't belong to an allowed hostname.
+// Requests sending neither are rejected as well.
+func (f *originFilter) check(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		if origin == "" || origin == "null" {
+			origin = r.Header.Get("Referer")
+		}
+
+		if !f.allowOrigin(r, origin) {
+			f.rejected.Add(1)
+
+			if config.LogRejectedOrigins {
+				slog.Info("Request from disallowed origin rejected", "origin", origin, "path", r.URL.Path, "ip", getIP(r))
+			}
+
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOriginFilterAllowOrigin(t *testing.T) {
+	f := newOriginFilter([]string{"Example.com", "regex:^[a-z]+\\.example\\.com$"})
+	assert.True(t, f.allowOrigin(nil, "https://example.com"))
+	assert.True(t, f.allowOrigin(nil, "https://EXAMPLE.com:8080"))
+	assert.True(t, f.allowOrigin(nil, "https://blog.example.com/foo?bar=baz"))
+	assert.False(t, f.allowOrigin(nil, "https://example.com.evil.com"))
+	assert.False(t, f.allowOrigin(nil, "https://evil.com"))
+	assert.False(t, f.allowOrigin(nil, "null"))
+	assert.False(t, f.allowOrigin(nil, ""))
+}
+
+func TestOriginFilterCheck(t *testing.T) {
+	setTestClients(t)
+	f := newOriginFilter([]string{"example.com"})
+	handler := f.check(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, header := range []map[string]string{
+		{"Origin": "https://example.com"},
+		{"Referer": "https://example.com/foo"},
+		{"Origin": "null", "Referer": "https://example.com/foo"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/p/e", nil)
+
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	for _, header := range []map[string]string{
+		{},
+		{"Origin": "https://evil.com"},
+		{"Origin": "https://evil.com", "Referer": "https://example.com/foo"},
+		{"Referer": "https://evil.com/foo"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/p/e", nil)
+
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+
+	assert.Equal(t, uint64(4), f.rejected.Load())
+}
+
+func TestGetRouterAllowedOrigins(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	config.BasePath = "/p"
+	config.PageViewPath = "pv"
+	config.EventPath = "e"
+	config.SessionPath = "s"
+	config.JSFilename = "pa.js"
+	config.AllowedOrigins = []string{"example.com"}
+	t.Cleanup(func() {
+		origins = nil
+	})
+	router := GetRouter()
+	req := httptest.NewRequest(http.MethodOptions, "/p/e", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	req.Header.Set("Origin", "https://evil.com")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	req = httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo", nil)
+	req.Header.Set("Origin", "https://example.com")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	req.Header.Set("Origin", "https://evil.com")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Len(t, mock.received(), 1)
+	assert.Equal(t, uint64(1), origins.rejected.Load())
+}
+
+func TestGetRouterAllowedOriginsPixelPlausible(t *testing.T) {
+	_, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	config.BasePath = "/p"
+	config.PixelPath = "px"
+	config.JSFilename = "pa.js"
+	config.Plausible = Plausible{Enabled: true, Path: "/api/event"}
+	config.AllowedOrigins = []string{"example.com"}
+	t.Cleanup(func() {
+		origins = nil
+	})
+	router := GetRouter()
+	req := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(`{"name": "pageview", "url": "https://example.com/"}`))
+	req.Header.Set("Origin", "https://evil.com")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// the pixel is only checked if configured
+	req = httptest.NewRequest(http.MethodGet, "/p/px?url=https://example.com/", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	config.CheckPixelOrigin = true
+	router = GetRouter()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	req.Header.Set("Referer", "https://example.com/")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// transparentGIF is a 1x1 transparent GIF image.
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// pixel tracks a page view for clients not running JavaScript and responds with a transparent GIF.
+// It accepts the same query parameters as the page view endpoint and falls back to the Referer header for the page URL.
+func pixel(w http.ResponseWriter, r *http.Request) {
+	hit := getPageViewHit(r)
+
+	if hit.Options.URL == "" {
+		hit.Options.URL = r.Header.Get("Referer")
+	}
+
+	// the image is always returned, as the visitor won't see any errors anyway
+	if err := verifyToken(hit, getToken(r, r.URL.Query().Get("token"))); err == nil {
+		fanOut(hit)
+	}
+
+	writePixel(w)
+}
+
+func writePixel(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+
+	if _, err := w.Write(transparentGIF); err != nil {
+		slog.Error("Error sending pixel", "err", err)
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"bytes"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPixel(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server, NewHostnameFilter([]string{"example.com"})))
+	req := httptest.NewRequest(http.MethodGet, "/p/px?url=https://example.com/foo&t=Foo", nil)
+	w := httptest.NewRecorder()
+	pixel(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/gif", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Cache-Control"), "no-store")
+	img, err := gif.Decode(bytes.NewReader(w.Body.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, img.Bounds().Dx())
+	assert.Equal(t, 1, img.Bounds().Dy())
+	_, _, _, a := img.At(0, 0).RGBA()
+	assert.Zero(t, a)
+	req = httptest.NewRequest(http.MethodGet, "/p/px?ref=https://google.com", nil)
+	req.Header.Set("Referer", "https://example.com/bar")
+	w = httptest.NewRecorder()
+	pixel(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	req = httptest.NewRequest(http.MethodGet, "/p/px", nil)
+	req.Header.Set("Referer", "https://filtered.com/bar")
+	w = httptest.NewRecorder()
+	pixel(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	hits := mock.received()
+	assert.Len(t, hits, 2)
+	assert.Equal(t, "https://example.com/foo", hits[0].URL)
+	assert.Equal(t, "Foo", hits[0].Title)
+	assert.Equal(t, "https://example.com/bar", hits[1].URL)
+	assert.Equal(t, "https://google.com", hits[1].Referrer)
+}
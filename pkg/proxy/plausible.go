@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const (
+	plausiblePageView = "pageview"
+)
+
+// plausibleEvent is a Plausible Events API request.
+// The Plausible script uses the short field names, while the API documentation uses the long ones.
+type plausibleEvent struct {
+	Name          string          `json:"name"`
+	ShortName     string          `json:"n"`
+	URL           string          `json:"url"`
+	ShortURL      string          `json:"u"`
+	Domain        string          `json:"domain"`
+	ShortDomain   string          `json:"d"`
+	Referrer      string          `json:"referrer"`
+	ShortReferrer string          `json:"r"`
+	ScreenWidth   int             `json:"screen_width"`
+	ShortWidth    int             `json:"w"`
+	Props         json.RawMessage `json:"props"`
+	ShortProps    json.RawMessage `json:"p"`
+}
+
+// plausible translates a Plausible event to a page view or event.
+// The domain selects the clients using their hostname filter. Multiple domains can be separated by a comma.
+// Like Plausible, it responds with 202 Accepted on success.
+func plausible(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(w, r)
+
+	if err != nil {
+		w.WriteHeader(getBodyErrorStatus(err))
+		return
+	}
+
+	var event plausibleEvent
+
+	if err := json.Unmarshal(body, &event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	name := getPlausibleField(event.Name, event.ShortName)
+	domain := getPlausibleField(event.Domain, event.ShortDomain)
+	props, err := getPlausibleProps(event.Props, event.ShortProps)
+
+	if err != nil || name == "" || domain == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	kind := eventHit
+
+	if name == plausiblePageView {
+		kind = pageViewHit
+	}
+
+	hit := newHit(kind, r)
+	hit.Options.URL = getPlausibleField(event.URL, event.ShortURL)
+	hit.Options.Referrer = getPlausibleField(event.Referrer, event.ShortReferrer)
+	hit.Options.ScreenWidth = max(event.ScreenWidth, event.ShortWidth)
+
+	if kind == eventHit {
+		hit.EventName = name
+		hit.EventMeta = props
+	}
+
+	if err := hit.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	domains := strings.Split(strings.ToLower(domain), ",")
+
+	for i := range domains {
+		domains[i] = strings.TrimSpace(domains[i])
+	}
+
+	status := getResponseStatus(fanOutTo(hit, getClientsByDomain(domains)), config.Policy)
+
+	if status == http.StatusOK {
+		status = http.StatusAccepted
+	}
+
+	w.WriteHeader(status)
+
+	if status == http.StatusAccepted {
+		_, _ = w.Write([]byte("ok"))
+	}
+}
+
+func getPlausibleField(long, short string) string {
+	if long != "" {
+		return long
+	}
+
+	return short
+}
+
+// getPlausibleProps returns the custom properties as event metadata.
+// The properties can either be an object or an object encoded as a JSON string.
+func getPlausibleProps(long, short json.RawMessage) (map[string]string, error) {
+	data := long
+
+	if len(data) == 0 {
+		data = short
+	}
+
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	var encoded string
+
+	if err := json.Unmarshal(data, &encoded); err == nil {
+		data = []byte(encoded)
+	}
+
+	var props map[string]any
+
+	if err := json.Unmarshal(data, &props); err != nil {
+		return nil, err
+	}
+
+	if len(props) == 0 {
+		return nil, nil
+	}
+
+	meta := make(map[string]string, len(props))
+
+	for key, value := range props {
+		meta[key] = formatValue(value)
+	}
+
+	return meta, nil
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlausible(t *testing.T) {
+	mock, server := newAPIMock(t)
+	otherMock, otherServer := newAPIMock(t)
+	blog := newTestClient("blog", server)
+	blog.hostname = NewHostnameFilter([]string{"example.com"})
+	shop := newTestClient("shop", otherServer)
+	shop.hostname = NewHostnameFilter([]string{"shop.com"})
+	setTestClients(t, blog, shop)
+	req := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(`{"n": "pageview", "u": "https://www.example.com/foo", "d": "example.com", "r": "https://google.com", "w": 1920}`))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	plausible(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+	req = httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(`{"name": "Signup", "url": "https://example.com/foo", "domain": "example.com", "props": {"plan": "pro", "seats": 3}}`))
+	w = httptest.NewRecorder()
+	plausible(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, otherMock.received())
+	hits := mock.received()
+	assert.Len(t, hits, 2)
+	assert.Empty(t, hits[0].Name)
+	assert.Equal(t, "https://www.example.com/foo", hits[0].URL)
+	assert.Equal(t, "https://google.com", hits[0].Referrer)
+	assert.Equal(t, 1920, hits[0].ScreenWidth)
+	assert.Equal(t, "Signup", hits[1].Name)
+	assert.Equal(t, map[string]string{"plan": "pro", "seats": "3"}, hits[1].Metadata)
+	req = httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(`{"n": "pageview", "u": "https://shop.com/", "d": "example.com, shop.com"}`))
+	w = httptest.NewRecorder()
+	plausible(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Len(t, otherMock.received(), 1)
+}
+
+func TestPlausibleInvalid(t *testing.T) {
+	setTestClients(t)
+
+	for _, body := range []string{
+		`{`,
+		`{"n": "pageview", "u": "https://example.com"}`,
+		`{"u": "https://example.com", "d": "example.com"}`,
+		`{"n": "pageview", "u": "/foo", "d": "example.com"}`,
+		`{"n": "Signup", "u": "https://example.com", "d": "example.com", "p": "invalid"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/event", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		plausible(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestGetPlausibleProps(t *testing.T) {
+	props, err := getPlausibleProps(json.RawMessage(`{"plan": "pro"}`), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"plan": "pro"}, props)
+	props, err = getPlausibleProps(nil, json.RawMessage(`"{\"plan\": \"pro\", \"trial\": true}"`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"plan": "pro", "trial": "true"}, props)
+	props, err = getPlausibleProps(nil, json.RawMessage(`null`))
+	assert.NoError(t, err)
+	assert.Nil(t, props)
+}
@@ -0,0 +1,378 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentExt    = ".seg"
+	cursorFile    = "cursor"
+	cursorTmpFile = "cursor.tmp"
+)
+
+var (
+	errQueueFull = errors.New("queue is full")
+)
+
+// queue is a persistent write-ahead queue for hits.
+// Hits are appended to segment files on disk, one JSON encoded hit per line.
+// The read position is stored in a cursor file, so that hits that have not been delivered yet survive a restart.
+// Segments are synced after each append and the cursor is replaced atomically, so that a crash neither loses nor replays hits.
+type queue struct {
+	dir         string
+	maxSize     int64
+	segmentSize int64
+	size        int64
+	notify      chan struct{}
+	m           sync.Mutex
+
+	writeSeq  int64
+	write     *os.File
+	writeSize int64
+
+	readSeq    int64
+	readOffset int64
+	read       *os.File
+	reader     *bufio.Reader
+	pending    []byte
+}
+
+func newQueue(dir string, maxSize, segmentSize int64) (*queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	q := &queue{
+		dir:         dir,
+		maxSize:     maxSize,
+		segmentSize: segmentSize,
+		notify:      make(chan struct{}, 1),
+	}
+	segments, err := q.segments()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, seq := range segments {
+		info, err := os.Stat(q.segmentPath(seq))
+
+		if err != nil {
+			return nil, err
+		}
+
+		q.size += info.Size()
+	}
+
+	if len(segments) > 0 {
+		q.readSeq = segments[0]
+		q.writeSeq = segments[len(segments)-1]
+	}
+
+	q.loadCursor()
+
+	for _, seq := range segments {
+		if seq < q.readSeq {
+			if err := q.removeSegment(seq); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// always start a new segment, as the last one might end with a partially written hit
+	if err := q.rotate(); err != nil {
+		return nil, err
+	}
+
+	if q.readSeq == 0 {
+		q.readSeq = q.writeSeq
+	}
+
+	return q, nil
+}
+
+func (q *queue) push(hit *Hit) error {
+	data, err := json.Marshal(hit)
+
+	if err != nil {
+		return err
+	}
+
+	data = append(data, '\n')
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.size+int64(len(data)) > q.maxSize {
+		return errQueueFull
+	}
+
+	if q.writeSize > 0 && q.writeSize+int64(len(data)) > q.segmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := q.write.Write(data)
+	q.writeSize += int64(n)
+	q.size += int64(n)
+
+	if err != nil {
+		return err
+	}
+
+	if err := q.write.Sync(); err != nil {
+		return err
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// peek returns the next hit without removing it from the queue.
+// It returns nil if the queue is empty.
+func (q *queue) peek() (*Hit, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	for {
+		if q.pending == nil {
+			if err := q.readNext(); err != nil {
+				return nil, err
+			}
+
+			if q.pending == nil {
+				return nil, nil
+			}
+		}
+
+		hit := new(Hit)
+
+		if err := json.Unmarshal(q.pending, hit); err != nil {
+			slog.Error("Error decoding queued hit, skipping", "err", err, "dir", q.dir)
+			q.advance()
+			continue
+		}
+
+		return hit, nil
+	}
+}
+
+// pop removes the hit returned by the last call to peek.
+func (q *queue) pop() {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.advance()
+}
+
+// bytes returns the size of the queue on disk.
+func (q *queue) bytes() int64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.size
+}
+
+func (q *queue) close() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.read != nil {
+		_ = q.read.Close()
+		q.read = nil
+	}
+
+	return q.write.Close()
+}
+
+func (q *queue) readNext() error {
+	for {
+		if q.read == nil {
+			f, err := os.Open(q.segmentPath(q.readSeq))
+
+			if err != nil {
+				return err
+			}
+
+			if _, err := f.Seek(q.readOffset, io.SeekStart); err != nil {
+				_ = f.Close()
+				return err
+			}
+
+			q.read = f
+			q.reader = bufio.NewReader(f)
+		}
+
+		line, err := q.reader.ReadBytes('\n')
+
+		if err == nil {
+			q.pending = line
+			return nil
+		}
+
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if q.readSeq == q.writeSeq {
+			// nothing has been written since, so start over from the current offset next time
+			_ = q.read.Close()
+			q.read = nil
+			return nil
+		}
+
+		// segment has been read completely (a partially written line is dropped)
+		if err := q.removeSegment(q.readSeq); err != nil {
+			return err
+		}
+
+		q.readSeq++
+		q.readOffset = 0
+		q.saveCursor()
+	}
+}
+
+func (q *queue) advance() {
+	q.readOffset += int64(len(q.pending))
+	q.pending = nil
+	q.saveCursor()
+}
+
+func (q *queue) rotate() error {
+	if q.write != nil {
+		if err := q.write.Close(); err != nil {
+			return err
+		}
+	}
+
+	q.writeSeq++
+	f, err := os.OpenFile(q.segmentPath(q.writeSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	q.write = f
+	q.writeSize = 0
+	return nil
+}
+
+func (q *queue) removeSegment(seq int64) error {
+	if q.read != nil {
+		_ = q.read.Close()
+		q.read = nil
+	}
+
+	path := q.segmentPath(seq)
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+
+	q.size -= info.Size()
+	return nil
+}
+
+func (q *queue) segments() ([]int64, error) {
+	entries, err := os.ReadDir(q.dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]int64, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), segmentExt), 10, 64)
+
+		if err != nil {
+			continue
+		}
+
+		segments = append(segments, seq)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i] < segments[j]
+	})
+	return segments, nil
+}
+
+func (q *queue) loadCursor() {
+	data, err := os.ReadFile(filepath.Join(q.dir, cursorFile))
+
+	if err != nil {
+		return
+	}
+
+	var seq, offset int64
+
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seq, &offset); err != nil {
+		slog.Error("Error reading queue cursor", "err", err, "dir", q.dir)
+		return
+	}
+
+	// the cursor might point to a segment that has been removed already
+	if seq >= q.readSeq && seq <= q.writeSeq {
+		q.readSeq = seq
+		q.readOffset = offset
+	}
+}
+
+func (q *queue) saveCursor() {
+	if err := q.writeCursor(); err != nil {
+		slog.Error("Error saving queue cursor", "err", err, "dir", q.dir)
+	}
+}
+
+// writeCursor writes the cursor to a temporary file and renames it, so that the cursor file is never partially written.
+func (q *queue) writeCursor() error {
+	path := filepath.Join(q.dir, cursorTmpFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(f, "%d %d", q.readSeq, q.readOffset); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(path, filepath.Join(q.dir, cursorFile))
+}
+
+func (q *queue) segmentPath(seq int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
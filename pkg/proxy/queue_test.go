@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newQueue(dir, 1024*1024, 512)
+	assert.NoError(t, err)
+	hit, err := q.peek()
+	assert.NoError(t, err)
+	assert.Nil(t, hit)
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		assert.NoError(t, q.push(&Hit{Kind: eventHit, EventName: name}))
+	}
+
+	for _, name := range []string{"a", "b"} {
+		hit, err = q.peek()
+		assert.NoError(t, err)
+		assert.Equal(t, name, hit.EventName)
+		hit, err = q.peek()
+		assert.NoError(t, err)
+		assert.Equal(t, name, hit.EventName)
+		q.pop()
+	}
+
+	// the queue continues where it left off after a restart
+	assert.NoError(t, q.close())
+	q, err = newQueue(dir, 1024*1024, 512)
+	assert.NoError(t, err)
+
+	for _, name := range []string{"c", "d", "e"} {
+		hit, err = q.peek()
+		assert.NoError(t, err)
+		assert.Equal(t, name, hit.EventName)
+		q.pop()
+	}
+
+	hit, err = q.peek()
+	assert.NoError(t, err)
+	assert.Nil(t, hit)
+	segments, err := q.segments()
+	assert.NoError(t, err)
+	assert.Len(t, segments, 1)
+	assert.NoError(t, q.close())
+}
+
+func TestQueueMaxSize(t *testing.T) {
+	q, err := newQueue(t.TempDir(), 500, 100)
+	assert.NoError(t, err)
+	assert.NoError(t, q.push(&Hit{Kind: pageViewHit}))
+	assert.ErrorIs(t, q.push(&Hit{Kind: pageViewHit}), errQueueFull)
+	_, err = q.peek()
+	assert.NoError(t, err)
+	q.pop()
+	assert.ErrorIs(t, q.push(&Hit{Kind: pageViewHit}), errQueueFull)
+	assert.NoError(t, q.close())
+}
+
+func TestQueuePartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newQueue(dir, 1024*1024, 1024*1024)
+	assert.NoError(t, err)
+	assert.NoError(t, q.push(&Hit{Kind: eventHit, EventName: "a"}))
+	_, err = q.write.WriteString(`{"kind":"eve`)
+	assert.NoError(t, err)
+	assert.NoError(t, q.close())
+	q, err = newQueue(dir, 1024*1024, 1024*1024)
+	assert.NoError(t, err)
+	assert.NoError(t, q.push(&Hit{Kind: eventHit, EventName: "b"}))
+	hit, err := q.peek()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", hit.EventName)
+	q.pop()
+	hit, err = q.peek()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", hit.EventName)
+	q.pop()
+	_, err = os.Stat(q.segmentPath(1))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, q.close())
+}
+
+func TestQueueCursor(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newQueue(dir, 1024*1024, 1024*1024)
+	assert.NoError(t, err)
+	assert.NoError(t, q.push(&Hit{Kind: eventHit, EventName: "a"}))
+	assert.NoError(t, q.push(&Hit{Kind: eventHit, EventName: "b"}))
+	_, err = q.peek()
+	assert.NoError(t, err)
+	q.pop()
+	assert.NoError(t, q.close())
+	_, err = os.Stat(filepath.Join(dir, cursorTmpFile))
+	assert.True(t, os.IsNotExist(err))
+
+	// a temporary cursor left behind by a crash is ignored
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, cursorTmpFile), []byte("1"), 0600))
+	q, err = newQueue(dir, 1024*1024, 1024*1024)
+	assert.NoError(t, err)
+	hit, err := q.peek()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", hit.EventName)
+	assert.NoError(t, q.close())
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket rate limiter.
+// The buckets are kept in an LRU list of limited size, so that the memory used is bounded.
+// Evicting a bucket only resets the limit for that key, which is fine for well-behaved visitors.
+type rateLimiter struct {
+	rate       float64
+	burst      float64
+	maxEntries int
+	buckets    map[string]*list.Element
+	lru        *list.List
+	m          sync.Mutex
+}
+
+type rateLimitBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst, maxEntries int) *rateLimiter {
+	b := float64(burst)
+
+	if b <= 0 {
+		b = max(1, math.Ceil(rate))
+	}
+
+	return &rateLimiter{
+		rate:       rate,
+		burst:      b,
+		maxEntries: maxEntries,
+		buckets:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// rateLimit returns a middleware limiting the request rate per IP according to the rule.
+// If a hostname rate is configured, the rate per IP and hostname of the page is limited in addition.
+// Requests exceeding a limit are rejected with 429 Too Many Requests and a Retry-After header.
+// The middleware does nothing if no rate is configured.
+func rateLimit(rule RateLimitRule) func(http.Handler) http.Handler {
+	if rule.Rate <= 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	limiter := newRateLimiter(rule.Rate, rule.Burst, config.RateLimit.MaxEntries)
+	var hostnameLimiter *rateLimiter
+
+	if rule.HostnameRate > 0 {
+		hostnameLimiter = newRateLimiter(rule.HostnameRate, rule.HostnameBurst, config.RateLimit.MaxEntries)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, now := getIP(r), time.Now()
+			wait := limiter.allow(ip, now)
+
+			// the hostname is chosen by the caller, so it's only checked once the per-IP limit has passed
+			// to prevent a single IP from evicting the buckets of other visitors by sending random hostnames
+			if wait == 0 && hostnameLimiter != nil {
+				wait = hostnameLimiter.allow(ip+" "+getRequestHostname(r), now)
+			}
+
+			if wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allow takes a token from the bucket for the key.
+// It returns zero if the request is allowed or the time to wait for the next token otherwise.
+func (l *rateLimiter) allow(key string, now time.Time) time.Duration {
+	l.m.Lock()
+	defer l.m.Unlock()
+	var bucket *rateLimitBucket
+
+	if element, found := l.buckets[key]; found {
+		l.lru.MoveToFront(element)
+		bucket = element.Value.(*rateLimitBucket)
+		bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+		bucket.last = now
+	} else {
+		if l.lru.Len() >= l.maxEntries {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*rateLimitBucket).key)
+		}
+
+		bucket = &rateLimitBucket{key: key, tokens: l.burst, last: now}
+		l.buckets[key] = l.lru.PushFront(bucket)
+	}
+
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+
+	bucket.tokens--
+	return 0
+}
+
+// getRequestHostname returns the hostname of the page the request was sent from.
+// It uses the url query parameter if set and the Referer or Origin header otherwise.
+func getRequestHostname(r *http.Request) string {
+	for _, rawURL := range []string{r.URL.Query().Get("url"), r.Header.Get("Referer"), r.Header.Get("Origin")} {
+		if rawURL == "" {
+			continue
+		}
+
+		if u, err := url.Parse(rawURL); err == nil && u.Hostname() != "" {
+			return strings.ToLower(u.Hostname())
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(2, 3, 10)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		assert.Zero(t, l.allow("1.2.3.4", now))
+	}
+
+	assert.Equal(t, time.Millisecond*500, l.allow("1.2.3.4", now))
+	assert.Zero(t, l.allow("5.6.7.8", now))
+	assert.Equal(t, time.Millisecond*250, l.allow("1.2.3.4", now.Add(time.Millisecond*250)))
+	assert.Zero(t, l.allow("1.2.3.4", now.Add(time.Millisecond*500)))
+	assert.Zero(t, l.allow("1.2.3.4", now.Add(time.Hour)))
+	assert.Zero(t, l.allow("1.2.3.4", now.Add(time.Hour)))
+	assert.Zero(t, l.allow("1.2.3.4", now.Add(time.Hour)))
+	assert.NotZero(t, l.allow("1.2.3.4", now.Add(time.Hour)))
+	assert.Equal(t, float64(2), newRateLimiter(1.5, 0, 10).burst)
+}
+
+func TestRateLimiterMaxEntries(t *testing.T) {
+	l := newRateLimiter(1, 1, 2)
+	now := time.Now()
+	assert.Zero(t, l.allow("a", now))
+	assert.Zero(t, l.allow("b", now))
+	assert.NotZero(t, l.allow("a", now))
+	assert.Zero(t, l.allow("c", now))
+	assert.Len(t, l.buckets, 2)
+	assert.Equal(t, 2, l.lru.Len())
+
+	// b was the least recently used bucket and has been evicted
+	assert.Zero(t, l.allow("b", now))
+	assert.NotContains(t, l.buckets, "a")
+}
+
+func TestRateLimit(t *testing.T) {
+	setTestClients(t)
+	config.RateLimit = RateLimit{MaxEntries: 10}
+	handler := rateLimit(RateLimitRule{Rate: 1, Burst: 2, HostnameRate: 1, HostnameBurst: 1})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(rawURL string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, rawURL, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, request("/p/pv?url=https://example.com/foo").Code)
+	w := request("/p/pv?url=https://example.com/bar")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// the per-IP limit applies regardless of the hostname
+	for _, hostname := range []string{"other.com", "a1.com", "a2.com"} {
+		assert.Equal(t, http.StatusTooManyRequests, request("/p/pv?url=https://"+hostname+"/foo").Code)
+	}
+
+	handler = rateLimit(RateLimitRule{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, request("/p/pv?url=https://example.com/foo").Code)
+	}
+}
+
+func TestGetRequestHostname(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/p/e?url=https://Example.com/foo", nil)
+	assert.Equal(t, "example.com", getRequestHostname(req))
+	req = httptest.NewRequest(http.MethodPost, "/p/e", nil)
+	req.Header.Set("Referer", "https://referer.com/foo")
+	req.Header.Set("Origin", "https://origin.com")
+	assert.Equal(t, "referer.com", getRequestHostname(req))
+	req.Header.Del("Referer")
+	assert.Equal(t, "origin.com", getRequestHostname(req))
+	req.Header.Del("Origin")
+	assert.Empty(t, getRequestHostname(req))
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"path"
+
+	pirsch "github.com/pirsch-analytics/pirsch-go-sdk/v2/pkg"
+)
+
+// SignRedirect returns the signature for the redirect destination to pass as the "sig" query parameter.
+func SignRedirect(secret, to string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(to))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// redirect tracks an outbound link or file download as an event and redirects to the destination.
+// The destination must either match the allowed hostnames or be signed using the configured secret.
+// If tracking tokens are enabled, the token for the page the link is on must be passed as the token query parameter.
+func redirect(allowed FilterFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		to := query.Get("to")
+		u, err := url.Parse(to)
+
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if !validRedirect(allowed, to, query.Get("sig")) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		hit := newHit(eventHit, r)
+		hit.Options.URL = query.Get("from")
+
+		if hit.Options.URL == "" {
+			hit.Options.URL = r.Header.Get("Referer")
+		}
+
+		if query.Get("download") != "" {
+			hit.EventName = config.Redirect.DownloadEventName
+			hit.EventMeta = map[string]string{"url": to, "filename": path.Base(u.Path)}
+		} else {
+			hit.EventName = config.Redirect.EventName
+			hit.EventMeta = map[string]string{"url": to}
+		}
+
+		// the visitor is redirected anyway, but the event is only tracked for a valid page URL and token
+		if hit.validate() == nil && verifyToken(hit, getToken(r, query.Get("token"))) == nil {
+			// don't keep the visitor waiting for the event to be delivered
+			fanOutBackground(hit)
+		}
+
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, max-age=0")
+		http.Redirect(w, r, to, http.StatusFound)
+	}
+}
+
+func validRedirect(allowed FilterFunc, to, signature string) bool {
+	if allowed != nil && allowed(&Hit{Options: pirsch.PageViewOptions{URL: to}}) {
+		return true
+	}
+
+	if config.Redirect.Secret != "" && signature != "" {
+		expected, err := hex.DecodeString(SignRedirect(config.Redirect.Secret, to))
+
+		if err != nil {
+			return false
+		}
+
+		actual, err := hex.DecodeString(signature)
+		return err == nil && hmac.Equal(expected, actual)
+	}
+
+	return false
+}
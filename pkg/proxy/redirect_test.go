@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirect(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	config.Redirect = Redirect{
+		EventName:         "Outbound Link",
+		DownloadEventName: "File Download",
+		Secret:            "secret",
+	}
+	handler := redirect(NewHostnameFilter([]string{"github.com", "regex:^[a-z]+\\.example\\.com$"}))
+	req := httptest.NewRequest(http.MethodGet, "/p/r?to=https://github.com/pirsch-analytics&from=https://example.com/blog", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://github.com/pirsch-analytics", w.Header().Get("Location"))
+	assert.Eventually(t, func() bool {
+		return len(mock.received()) == 1
+	}, time.Second, time.Millisecond*5)
+	assert.Equal(t, "https://example.com/blog", mock.received()[0].URL)
+	assert.Equal(t, "Outbound Link", mock.received()[0].Name)
+	assert.Equal(t, map[string]string{"url": "https://github.com/pirsch-analytics"}, mock.received()[0].Metadata)
+
+	// downloads
+	req = httptest.NewRequest(http.MethodGet, "/p/r?download=1&to=https://files.example.com/report.pdf", nil)
+	req.Header.Set("Referer", "https://example.com/downloads")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Eventually(t, func() bool {
+		return len(mock.received()) == 2
+	}, time.Second, time.Millisecond*5)
+	assert.Equal(t, "https://example.com/downloads", mock.received()[1].URL)
+	assert.Equal(t, "File Download", mock.received()[1].Name)
+	assert.Equal(t, "report.pdf", mock.received()[1].Metadata["filename"])
+
+	// not allowed
+	for _, to := range []string{"https://evil.com", "https://evil.com/github.com", "//github.com", "javascript:alert(1)", ""} {
+		req = httptest.NewRequest(http.MethodGet, "/p/r?to="+url.QueryEscape(to), nil)
+		w = httptest.NewRecorder()
+		handler(w, req)
+		assert.NotEqual(t, http.StatusFound, w.Code)
+		assert.Empty(t, w.Header().Get("Location"))
+	}
+
+	// signed
+	to := "https://partner.com/landing?id=42"
+	req = httptest.NewRequest(http.MethodGet, "/p/r?to="+url.QueryEscape(to)+"&sig="+SignRedirect("secret", to), nil)
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, to, w.Header().Get("Location"))
+	req = httptest.NewRequest(http.MethodGet, "/p/r?to="+url.QueryEscape(to)+"&sig="+SignRedirect("wrong", to), nil)
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// redirected, but not tracked without an absolute page URL
+	req = httptest.NewRequest(http.MethodGet, "/p/r?to=https://github.com&from=/blog", nil)
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://github.com", w.Header().Get("Location"))
+	req = httptest.NewRequest(http.MethodGet, "/p/r?to=https://github.com&from=https://example.com/about", nil)
+	w = httptest.NewRecorder()
+	handler(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Eventually(t, func() bool {
+		return len(mock.received()) == 3
+	}, time.Second, time.Millisecond*5)
+	time.Sleep(time.Millisecond * 20)
+	assert.Len(t, mock.received(), 3)
+	assert.Equal(t, "https://example.com/about", mock.received()[2].URL)
+}
+
+func TestRedirectStopClients(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	config.Redirect = Redirect{EventName: "Outbound Link"}
+	handler := redirect(NewHostnameFilter([]string{"github.com"}))
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/p/r?to=https://github.com&from=https://example.com", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	// events delivered in the background are sent before the clients are stopped
+	StopClients()
+	assert.Len(t, mock.received(), 1)
+}
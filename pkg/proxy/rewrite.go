@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+)
+
+// getScriptPrologue returns JavaScript setting the default data attributes on the script tag.
+// It is prepended to the script, so that sites can embed a bare script tag without configuring the endpoints.
+// Endpoints are resolved relative to the script URL and attributes set on the script tag take precedence.
+func getScriptPrologue(endpoints, attributes map[string]string) ([]byte, error) {
+	e, err := json.Marshal(endpoints)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := json.Marshal(attributes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprintf(`(function(){var s=document.currentScript;if(!s)return;if(!s.id)s.id="pianjs";`+
+		`var e=%s,a=%s,k;`+
+		`for(k in e)if(!s.hasAttribute(k))s.setAttribute(k,new URL(e[k],s.src||location.href).href);`+
+		`for(k in a)if(!s.hasAttribute(k))s.setAttribute(k,a[k]);})();`+"\n", e, a)), nil
+}
+
+// getEndpointPrologue returns the prologue using the configured paths and identification code.
+func getEndpointPrologue() []byte {
+	attributes := make(map[string]string)
+
+	if config.Script.IdentificationCode != "" {
+		attributes["data-code"] = config.Script.IdentificationCode
+	}
+
+	prologue, err := getScriptPrologue(map[string]string{
+		"data-hit-endpoint":     path.Join(config.BasePath, config.PageViewPath),
+		"data-event-endpoint":   path.Join(config.BasePath, config.EventPath),
+		"data-session-endpoint": path.Join(config.BasePath, config.SessionPath),
+	}, attributes)
+
+	if err != nil {
+		slog.Error("Error creating script prologue", "err", err)
+		panic(err)
+	}
+
+	return prologue
+}
+
+// getPrologue returns the prologue for a script if rewrite is enabled.
+func getPrologue(rewrite bool) []byte {
+	if rewrite {
+		return getEndpointPrologue()
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEndpointPrologue(t *testing.T) {
+	config = &Config{BasePath: "/p", PageViewPath: "pv", EventPath: "e", SessionPath: "s"}
+	t.Cleanup(func() {
+		config = nil
+	})
+	prologue := string(getEndpointPrologue())
+	assert.True(t, strings.HasPrefix(prologue, "(function(){"))
+	assert.True(t, strings.HasSuffix(prologue, "})();\n"))
+	assert.Contains(t, prologue, `"data-hit-endpoint":"/p/pv"`)
+	assert.Contains(t, prologue, `"data-event-endpoint":"/p/e"`)
+	assert.Contains(t, prologue, `"data-session-endpoint":"/p/s"`)
+	assert.Contains(t, prologue, `a={}`)
+	config.Script.IdentificationCode = "abc\"123"
+	prologue = string(getEndpointPrologue())
+	assert.Contains(t, prologue, `a={"data-code":"abc\"123"}`)
+}
+
+func TestScriptRewrite(t *testing.T) {
+	dir := t.TempDir()
+	_, server := newUpstreamMock(t, "console.log('v1');")
+	s := newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, cacheDir: dir, ttl: time.Hour, prologue: []byte("prologue;\n")})
+	v, err := s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "prologue;\nconsole.log('v1');", string(v.content))
+
+	// the original script is cached
+	content, err := os.ReadFile(filepath.Join(dir, "pa.js"))
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(content))
+	s = newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, cacheDir: dir, ttl: time.Hour, prologue: []byte("prologue;\n")})
+	v, err = s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "prologue;\nconsole.log('v1');", string(v.content))
+}
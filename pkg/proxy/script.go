@@ -0,0 +1,457 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-chi/chi/v5"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	defaultScriptSourceURL = "https://api.pirsch.io"
+	scriptRetryInterval    = time.Minute
+	scriptDownloadTimeout  = time.Second * 10
+	scriptContentType      = "application/javascript; charset=utf-8"
+)
+
+var (
+	//go:embed static/pa.js
+	embeddedPaJS []byte
+
+	errScriptUnavailable = errors.New("script unavailable")
+	errScriptChecksum    = errors.New("script checksum mismatch")
+)
+
+// script is a JavaScript file downloaded from the upstream and served by the proxy.
+// The last good copy is kept in memory and optionally on disk.
+// Once the TTL has expired, the stale copy is served while a new one is downloaded in the background.
+// If no copy is available, the embedded fallback is served instead (if any).
+type script struct {
+	scriptOptions
+	version     *scriptVersion
+	fallback    *scriptVersion
+	updateAt    time.Time
+	downloading sync.Mutex
+	m           sync.RWMutex
+}
+
+type scriptOptions struct {
+	file      string
+	filename  string
+	sourceURL string
+	cacheDir  string
+	sha256    string
+	ttl       time.Duration
+	embedded  []byte
+	prologue  []byte
+	offline   bool
+}
+
+// scriptVersion is a copy of the script together with its precompressed variants.
+type scriptVersion struct {
+	content []byte
+	gzip    []byte
+	brotli  []byte
+	zstd    []byte
+	hash    string
+	modTime time.Time
+}
+
+func newScript(options scriptOptions) *script {
+	options.sourceURL = strings.TrimSuffix(options.sourceURL, "/")
+	options.sha256 = strings.ToLower(options.sha256)
+	s := &script{scriptOptions: options}
+
+	if options.embedded != nil {
+		v, err := newScriptVersion(s.rewrite(options.embedded), time.Time{})
+
+		if err != nil {
+			slog.Error("Error loading embedded script", "err", err, "file", s.file)
+			panic(err)
+		}
+
+		s.fallback = v
+	}
+
+	if options.cacheDir != "" {
+		s.loadCache()
+	}
+
+	return s
+}
+
+// cachePath returns the path of the cache file.
+// It is named by the filename the script is served under, as multiple scripts can share the same upstream file.
+func (s *script) cachePath() string {
+	name := s.filename
+
+	if name == "" {
+		name = s.file
+	}
+
+	return filepath.Join(s.cacheDir, strings.ReplaceAll(strings.Trim(name, "/"), "/", "_"))
+}
+
+// serveScripts sets up pa.js and all additional scripts configured.
+func serveScripts(router chi.Router) {
+	serveScript(router, config.JSFilename, newScript(scriptOptions{
+		file:      "pa.js",
+		filename:  config.JSFilename,
+		sourceURL: config.Script.SourceURL,
+		cacheDir:  config.Script.CachePath,
+		sha256:    config.Script.SHA256,
+		ttl:       time.Duration(config.Script.TTL) * time.Second,
+		embedded:  embeddedPaJS,
+		prologue:  getPrologue(config.Script.Rewrite),
+		offline:   config.Script.Offline,
+	}))
+
+	for _, s := range config.Scripts {
+		serveScript(router, s.Filename, newScript(scriptOptions{
+			file:      s.File,
+			filename:  s.Filename,
+			sourceURL: config.Script.SourceURL,
+			cacheDir:  config.Script.CachePath,
+			sha256:    s.SHA256,
+			ttl:       time.Duration(s.TTL) * time.Second,
+			prologue:  getPrologue(s.Rewrite),
+			offline:   config.Script.Offline,
+		}))
+	}
+}
+
+func serveScript(router chi.Router, filename string, s *script) {
+	maxAge := fmt.Sprintf("public, max-age=%d", config.Script.MaxAge)
+	router.HandleFunc(filepath.Join(config.BasePath, filename), func(w http.ResponseWriter, r *http.Request) {
+		v, err := s.get()
+
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		content, encoding := v.content, ""
+
+		switch acceptEncoding(r.Header.Get("Accept-Encoding")) {
+		case "br":
+			content, encoding = v.brotli, "br"
+		case "zstd":
+			content, encoding = v.zstd, "zstd"
+		case "gzip":
+			content, encoding = v.gzip, "gzip"
+		}
+
+		w.Header().Set("Content-Type", scriptContentType)
+		w.Header().Set("Cache-Control", maxAge)
+		w.Header().Set("Vary", "Accept-Encoding")
+
+		// each representation requires its own strong ETag
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+			w.Header().Set("ETag", strconv.Quote(v.hash+"-"+encoding))
+		} else {
+			w.Header().Set("ETag", strconv.Quote(v.hash))
+		}
+
+		// handles If-None-Match, If-Modified-Since, and range requests
+		http.ServeContent(w, r, filename, v.modTime, bytes.NewReader(content))
+	})
+}
+
+// acceptEncoding returns the preferred encoding for the Accept-Encoding header.
+// Brotli is preferred over zstd and gzip, as it compresses scripts best and is supported by all major browsers.
+func acceptEncoding(header string) string {
+	gz, br, zst := false, false, false
+
+	for _, part := range strings.Split(header, ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(encoding)) {
+		case "br":
+			br = true
+		case "zstd":
+			zst = true
+		case "gzip":
+			gz = true
+		}
+	}
+
+	if br {
+		return "br"
+	} else if zst {
+		return "zstd"
+	} else if gz {
+		return "gzip"
+	}
+
+	return ""
+}
+
+// get returns the current version of the script.
+// The script is downloaded if there is no copy available yet, unless the script is offline.
+func (s *script) get() (*scriptVersion, error) {
+	s.m.RLock()
+	v, expired := s.version, s.updateAt.Before(time.Now())
+	s.m.RUnlock()
+
+	if v == nil {
+		// don't retry right away if the last download failed recently
+		if !s.offline && expired {
+			if err := s.refresh(true); err != nil {
+				slog.Error("Error downloading script", "err", err, "file", s.file)
+			}
+
+			s.m.RLock()
+			v = s.version
+			s.m.RUnlock()
+		}
+
+		if v == nil {
+			v = s.fallback
+		}
+
+		if v == nil {
+			return nil, errScriptUnavailable
+		}
+
+		return v, nil
+	}
+
+	if expired && !s.offline {
+		go func() {
+			if err := s.refresh(false); err != nil {
+				slog.Error("Error refreshing script, serving stale copy", "err", err, "file", s.file)
+			}
+		}()
+	}
+
+	return v, nil
+}
+
+// refresh downloads the script.
+// If wait is false, it returns immediately in case another download is in progress already.
+func (s *script) refresh(wait bool) error {
+	if wait {
+		s.downloading.Lock()
+	} else if !s.downloading.TryLock() {
+		return nil
+	}
+
+	defer s.downloading.Unlock()
+	s.m.RLock()
+	upToDate, current := s.updateAt.After(time.Now()), s.version
+	s.m.RUnlock()
+
+	// another request has downloaded the script (or failed to) in the meantime
+	if upToDate {
+		return nil
+	}
+
+	content, err := s.download()
+
+	if err != nil {
+		s.m.Lock()
+		s.updateAt = time.Now().Add(min(s.ttl, scriptRetryInterval))
+		s.m.Unlock()
+		return err
+	}
+
+	// keep the current version if nothing has changed, so that browsers can keep using their cached copy
+	v := current
+	rewritten := s.rewrite(content)
+
+	if v == nil || !bytes.Equal(v.content, rewritten) {
+		v, err = newScriptVersion(rewritten, time.Now())
+
+		if err != nil {
+			return err
+		}
+
+		s.saveCache(content)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.version = v
+	s.updateAt = time.Now().Add(s.ttl)
+	return nil
+}
+
+func (s *script) download() ([]byte, error) {
+	c := http.Client{Timeout: scriptDownloadTimeout}
+	resp, err := c.Get(s.sourceURL + "/" + s.file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code %d downloading %s", resp.StatusCode, s.file)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verify(data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// verify checks the content against the pinned SHA-256 checksum if configured.
+func (s *script) verify(content []byte) error {
+	if s.sha256 == "" {
+		return nil
+	}
+
+	hash := sha256.Sum256(content)
+
+	if hex.EncodeToString(hash[:]) != s.sha256 {
+		return errScriptChecksum
+	}
+
+	return nil
+}
+
+// rewrite prepends the prologue to the script if configured.
+func (s *script) rewrite(content []byte) []byte {
+	if len(s.prologue) == 0 {
+		return content
+	}
+
+	rewritten := make([]byte, 0, len(s.prologue)+len(content))
+	return append(append(rewritten, s.prologue...), content...)
+}
+
+func (s *script) loadCache() {
+	path := s.cachePath()
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return
+	}
+
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		slog.Error("Error reading cached script", "err", err, "path", path)
+		return
+	}
+
+	if err := s.verify(content); err != nil {
+		slog.Error("Error verifying cached script", "err", err, "path", path)
+		return
+	}
+
+	v, err := newScriptVersion(s.rewrite(content), info.ModTime())
+
+	if err != nil {
+		slog.Error("Error loading cached script", "err", err, "path", path)
+		return
+	}
+
+	s.version = v
+	s.updateAt = info.ModTime().Add(s.ttl)
+}
+
+func (s *script) saveCache(content []byte) {
+	if s.cacheDir == "" {
+		return
+	}
+
+	path := s.cachePath()
+
+	if err := os.MkdirAll(s.cacheDir, 0700); err != nil {
+		slog.Error("Error creating script cache directory", "err", err, "path", path)
+		return
+	}
+
+	// write to a temporary file first, so that a partially written script is never loaded
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		slog.Error("Error caching script", "err", err, "path", path)
+		return
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		slog.Error("Error caching script", "err", err, "path", path)
+	}
+}
+
+func newScriptVersion(content []byte, modTime time.Time) (*scriptVersion, error) {
+	var gz bytes.Buffer
+	w, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var br bytes.Buffer
+	bw := brotli.NewWriterLevel(&br, brotli.BestCompression)
+
+	if _, err := bw.Write(content); err != nil {
+		return nil, err
+	}
+
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = enc.Close()
+	}()
+	hash := sha256.Sum256(content)
+	return &scriptVersion{
+		content: content,
+		gzip:    gz.Bytes(),
+		brotli:  br.Bytes(),
+		zstd:    enc.EncodeAll(content, nil),
+		hash:    hex.EncodeToString(hash[:16]),
+		modTime: modTime.UTC().Truncate(time.Second),
+	}, nil
+}
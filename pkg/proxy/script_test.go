@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-chi/chi/v5"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+type upstreamMock struct {
+	content   string
+	status    int
+	downloads int
+	m         sync.Mutex
+}
+
+func newUpstreamMock(t *testing.T, content string) (*upstreamMock, *httptest.Server) {
+	mock := &upstreamMock{content: content, status: http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mock.m.Lock()
+		defer mock.m.Unlock()
+		mock.downloads++
+		w.WriteHeader(mock.status)
+		_, _ = w.Write([]byte(mock.content))
+	}))
+	t.Cleanup(server.Close)
+	return mock, server
+}
+
+func (mock *upstreamMock) set(content string, status int) {
+	mock.m.Lock()
+	defer mock.m.Unlock()
+	mock.content = content
+	mock.status = status
+}
+
+func (mock *upstreamMock) count() int {
+	mock.m.Lock()
+	defer mock.m.Unlock()
+	return mock.downloads
+}
+
+func TestScript(t *testing.T) {
+	mock, server := newUpstreamMock(t, "console.log('v1');")
+	s := newScript(scriptOptions{file: "pa.js", sourceURL: server.URL + "/", ttl: time.Millisecond * 20})
+	v, err := s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(v.content))
+	v, err = s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(v.content))
+	assert.Equal(t, 1, mock.count())
+
+	// the stale copy is served if the refresh fails
+	mock.set("error", http.StatusInternalServerError)
+	time.Sleep(time.Millisecond * 25)
+	v, err = s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(v.content))
+	assert.Eventually(t, func() bool {
+		return mock.count() == 2
+	}, time.Second, time.Millisecond)
+
+	// the new copy is served once it has been downloaded
+	mock.set("console.log('v2');", http.StatusOK)
+	assert.Eventually(t, func() bool {
+		v, err = s.get()
+		return string(v.content) == "console.log('v2');"
+	}, time.Second, time.Millisecond*5)
+}
+
+func TestScriptUnavailable(t *testing.T) {
+	mock, server := newUpstreamMock(t, "error")
+	mock.set("error", http.StatusNotFound)
+	s := newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, ttl: time.Hour})
+	v, err := s.get()
+	assert.Error(t, err)
+	assert.Nil(t, v)
+
+	// the download is not retried right away
+	_, err = s.get()
+	assert.ErrorIs(t, err, errScriptUnavailable)
+	assert.Equal(t, 1, mock.count())
+}
+
+func TestScriptChecksum(t *testing.T) {
+	hash := sha256.Sum256([]byte("console.log('v1');"))
+	checksum := hex.EncodeToString(hash[:])
+	mock, server := newUpstreamMock(t, "console.log('v1');")
+	s := newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, sha256: checksum, ttl: time.Millisecond})
+	v, err := s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(v.content))
+	mock.set("console.log('changed');", http.StatusOK)
+	time.Sleep(time.Millisecond * 2)
+	assert.ErrorIs(t, s.refresh(true), errScriptChecksum)
+	v, err = s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(v.content))
+}
+
+func TestScriptCache(t *testing.T) {
+	dir := t.TempDir()
+	mock, server := newUpstreamMock(t, "console.log('v1');")
+	s := newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, cacheDir: dir, ttl: time.Hour})
+	_, err := s.get()
+	assert.NoError(t, err)
+
+	// the cached copy is loaded on startup without contacting the upstream
+	mock.set("error", http.StatusInternalServerError)
+	s = newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, cacheDir: dir, ttl: time.Hour})
+	v, err := s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(v.content))
+	assert.Equal(t, 1, mock.count())
+
+	// the cached copy is ignored if it does not match the checksum
+	s = newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, cacheDir: dir, sha256: "0000", ttl: time.Hour})
+	_, err = s.get()
+	assert.Error(t, err)
+}
+
+func TestScriptCacheFilename(t *testing.T) {
+	dir := t.TempDir()
+	_, server := newUpstreamMock(t, "console.log('v1');")
+	s := newScript(scriptOptions{file: "pa.js", filename: "pa.js", sourceURL: server.URL, cacheDir: dir, ttl: time.Hour})
+	legacy := newScript(scriptOptions{file: "pa.js", filename: "js/legacy.js", sourceURL: server.URL, cacheDir: dir, ttl: time.Hour})
+	assert.NotEqual(t, s.cachePath(), legacy.cachePath())
+	assert.Equal(t, filepath.Join(dir, "js_legacy.js"), legacy.cachePath())
+	_, err := legacy.get()
+	assert.NoError(t, err)
+	_, err = os.Stat(legacy.cachePath())
+	assert.NoError(t, err)
+	_, err = os.Stat(s.cachePath())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestScriptFallback(t *testing.T) {
+	mock, server := newUpstreamMock(t, "error")
+	mock.set("error", http.StatusNotFound)
+	s := newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, ttl: time.Hour, embedded: embeddedPaJS})
+	v, err := s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, embeddedPaJS, v.content)
+	assert.Equal(t, 1, mock.count())
+
+	// the fallback is served until the upstream is available again
+	v, err = s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, embeddedPaJS, v.content)
+	assert.Equal(t, 1, mock.count())
+}
+
+func TestEmbeddedScriptChecksum(t *testing.T) {
+	// the checksum of the embedded copy must be recorded in pa.js.sha256 (see "make update-pa.js")
+	checksum, err := os.ReadFile("static/pa.js.sha256")
+	assert.NoError(t, err)
+	hash := sha256.Sum256(embeddedPaJS)
+	assert.Equal(t, strings.TrimSpace(string(checksum)), hex.EncodeToString(hash[:]))
+}
+
+func TestScriptOffline(t *testing.T) {
+	mock, server := newUpstreamMock(t, "console.log('v1');")
+	s := newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, ttl: time.Hour, embedded: embeddedPaJS, offline: true})
+	v, err := s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, embeddedPaJS, v.content)
+
+	// a cached copy is preferred over the embedded script
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "pa.js"), []byte("console.log('cached');"), 0600))
+	s = newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, cacheDir: dir, ttl: time.Millisecond, embedded: embeddedPaJS, offline: true})
+	time.Sleep(time.Millisecond * 2)
+	v, err = s.get()
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('cached');", string(v.content))
+	assert.Equal(t, 0, mock.count())
+}
+
+func TestServeScript(t *testing.T) {
+	_, server := newUpstreamMock(t, "console.log('v1');")
+	config = &Config{BasePath: "/p", Script: Script{MaxAge: 60}}
+	t.Cleanup(func() {
+		config = nil
+	})
+	router := chi.NewRouter()
+	serveScript(router, "pa.js", newScript(scriptOptions{file: "pa.js", sourceURL: server.URL, ttl: time.Hour}))
+	req := httptest.NewRequest(http.MethodGet, "/p/pa.js", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "console.log('v1');", w.Body.String())
+	assert.Equal(t, scriptContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// conditional requests
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+	req.Header.Del("If-None-Match")
+	req.Header.Set("If-Modified-Since", time.Now().UTC().Add(time.Minute).Format(http.TimeFormat))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// precompressed variants
+	req = httptest.NewRequest(http.MethodGet, "/p/pa.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	r, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(content))
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "zstd", w.Header().Get("Content-Encoding"))
+	d, err := zstd.NewReader(nil)
+	assert.NoError(t, err)
+	content, err = d.DecodeAll(w.Body.Bytes(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(content))
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+	content, err = io.ReadAll(brotli.NewReader(w.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(content))
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	assert.Empty(t, acceptEncoding(""))
+	assert.Empty(t, acceptEncoding("deflate"))
+	assert.Equal(t, "br", acceptEncoding("deflate, br"))
+	assert.Equal(t, "br", acceptEncoding("gzip, deflate, br"))
+	assert.Equal(t, "br", acceptEncoding("gzip, deflate, br, zstd"))
+	assert.Equal(t, "zstd", acceptEncoding("gzip, br;q=0, zstd"))
+	assert.Equal(t, "gzip", acceptEncoding("gzip, zstd;q=0"))
+	assert.Empty(t, acceptEncoding("GZIP;q=0"))
+}
@@ -0,0 +1,158 @@
+// Fallback copy of the Pirsch tracking script, served by the proxy if neither the cache nor the upstream is available.
+// It supports page views, events, session extensions, tags, and the data-exclude, data-include, data-domain,
+// data-disable-query, data-disable-referrer, and data-disable-resolution attributes of the upstream script.
+// Its SHA-256 checksum is recorded in pa.js.sha256. Replace both with the upstream copy using "make update-pa.js".
+(function () {
+    "use strict";
+
+    const script = document.currentScript || document.getElementById("pianjs");
+
+    if (!script) {
+        return;
+    }
+
+    const attr = name => script.getAttribute(name);
+    const code = attr("data-code") || "";
+    const dev = attr("data-dev");
+    const hitEndpoint = attr("data-hit-endpoint") || "/p/pv";
+    const eventEndpoint = attr("data-event-endpoint") || "/p/e";
+    const sessionEndpoint = attr("data-session-endpoint") || "/p/s";
+    const sessionInterval = parseInt(attr("data-interval-ms"), 10) || 60000;
+    const list = name => (attr(name) || "").split(",").map(v => v.trim()).filter(v => v !== "");
+    const exclude = list("data-exclude").map(v => new RegExp(v));
+    const include = list("data-include").map(v => new RegExp(v));
+    const domains = list("data-domain");
+    const disableQuery = script.hasAttribute("data-disable-query");
+    const disableReferrer = script.hasAttribute("data-disable-referrer");
+    const disableResolution = script.hasAttribute("data-disable-resolution");
+    const tags = {};
+
+    for (const a of script.attributes) {
+        if (a.name.startsWith("data-tag-")) {
+            tags[a.name.substring("data-tag-".length)] = a.value || "1";
+        }
+    }
+
+    if (!dev && /^localhost$|^127(\.[0-9]+){0,2}\.[0-9]+$|^\[::1?]$/.test(location.hostname)) {
+        console.info("Pirsch is ignored on localhost. Add the data-dev attribute to enable it.");
+        window.pirsch = () => Promise.resolve(null);
+        return;
+    }
+
+    const getURL = hostname => {
+        const url = new URL(location.href);
+
+        if (hostname || dev) {
+            url.hostname = hostname || dev;
+        }
+
+        if (disableQuery) {
+            url.search = "";
+        }
+
+        return url.toString();
+    };
+    const getURLs = () => [getURL()].concat(domains.map(getURL));
+    const getReferrer = () => disableReferrer ? "" : document.referrer;
+    const ignore = () => exclude.some(r => r.test(location.pathname)) ||
+        (include.length > 0 && !include.some(r => r.test(location.pathname)));
+    let lastHit = 0;
+
+    function pageView() {
+        if (ignore()) {
+            return;
+        }
+
+        for (const url of getURLs()) {
+            const params = new URLSearchParams({
+                nc: Date.now().toString(),
+                code: code,
+                url: url,
+                t: document.title,
+                ref: getReferrer(),
+                w: disableResolution ? "0" : screen.width.toString(),
+                h: disableResolution ? "0" : screen.height.toString()
+            });
+
+            for (const key in tags) {
+                params.set("tag_" + key, tags[key]);
+            }
+
+            const req = new XMLHttpRequest();
+            req.open("GET", hitEndpoint + "?" + params.toString());
+            req.send();
+        }
+
+        lastHit = Date.now();
+    }
+
+    function extendSession() {
+        if (!ignore() && document.visibilityState === "visible" && Date.now() - lastHit >= sessionInterval) {
+            for (const url of getURLs()) {
+                const params = new URLSearchParams({
+                    nc: Date.now().toString(),
+                    code: code,
+                    url: url
+                });
+
+                const req = new XMLHttpRequest();
+                req.open("POST", sessionEndpoint + "?" + params.toString());
+                req.send();
+            }
+
+            lastHit = Date.now();
+        }
+    }
+
+    window.pirsch = function (name, options) {
+        if (typeof name !== "string" || !name) {
+            return Promise.reject("The event name must be set");
+        }
+
+        if (ignore()) {
+            return Promise.resolve(null);
+        }
+
+        options = options || {};
+        return Promise.all(getURLs().map(url => new Promise((resolve, reject) => {
+            const req = new XMLHttpRequest();
+            req.open("POST", eventEndpoint);
+            req.setRequestHeader("Content-Type", "application/json;charset=UTF-8");
+            req.onload = () => req.status >= 200 && req.status < 300 ? resolve(req.response) : reject(req.statusText);
+            req.onerror = () => reject(req.statusText);
+            req.send(JSON.stringify({
+                identification_code: code,
+                url: url,
+                title: document.title,
+                referrer: getReferrer(),
+                screen_width: disableResolution ? 0 : screen.width,
+                screen_height: disableResolution ? 0 : screen.height,
+                event_name: name,
+                event_duration: options.duration && typeof options.duration === "number" ? options.duration : 0,
+                event_meta: options.meta || {},
+                tags: Object.assign({}, tags, options.tags || {})
+            }));
+        })));
+    };
+
+    if (!script.hasAttribute("data-disable-page-views")) {
+        if (history.pushState && !script.hasAttribute("data-disable-history")) {
+            const pushState = history.pushState;
+            history.pushState = function () {
+                pushState.apply(this, arguments);
+                pageView();
+            };
+            window.addEventListener("popstate", pageView);
+        }
+
+        if (document.body) {
+            pageView();
+        } else {
+            document.addEventListener("DOMContentLoaded", pageView);
+        }
+    }
+
+    if (script.hasAttribute("data-enable-sessions")) {
+        setInterval(extendSession, sessionInterval);
+    }
+})();
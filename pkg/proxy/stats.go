@@ -0,0 +1,428 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	pirsch "github.com/pirsch-analytics/pirsch-go-sdk/v2/pkg"
+)
+
+const (
+	statsDateFormat = "2006-01-02"
+)
+
+var (
+	errStatsDate = errors.New("date invalid")
+)
+
+// statsEndpoint reads statistics for the filter.
+type statsEndpoint func(*pirsch.Client, *pirsch.Filter, url.Values) (any, error)
+
+// statsEndpoints are all statistics endpoints provided by the SDK by name.
+var statsEndpoints = map[string]statsEndpoint{
+	"session_duration": func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.SessionDuration(f) },
+	"time_on_page":     func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.TimeOnPage(f) },
+	"utm_source":       func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.UTMSource(f) },
+	"utm_medium":       func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.UTMMedium(f) },
+	"utm_campaign":     func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.UTMCampaign(f) },
+	"utm_content":      func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.UTMContent(f) },
+	"utm_term":         func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.UTMTerm(f) },
+	"total":            func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.TotalVisitors(f) },
+	"visitors":         func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Visitors(f) },
+	"pages":            func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Pages(f) },
+	"entry_pages":      func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.EntryPages(f) },
+	"exit_pages":       func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.ExitPages(f) },
+	"conversion_goals": func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.ConversionGoals(f) },
+	"events":           func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Events(f) },
+	"event_metadata":   func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.EventMetadata(f) },
+	"event_pages":      func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.EventPages(f) },
+	"list_events":      func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.ListEvents(f) },
+	"growth":           func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Growth(f) },
+	"active_visitors":  func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.ActiveVisitors(f) },
+	"time_of_day":      func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.TimeOfDay(f) },
+	"languages":        func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Languages(f) },
+	"referrer":         func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Referrer(f) },
+	"os":               func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.OS(f) },
+	"os_versions":      func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.OSVersions(f) },
+	"browser":          func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Browser(f) },
+	"browser_versions": func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.BrowserVersions(f) },
+	"country":          func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Country(f) },
+	"region":           func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Region(f) },
+	"city":             func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.City(f) },
+	"platform":         func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Platform(f) },
+	"screen":           func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Screen(f) },
+	"tag_keys":         func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.TagKeys(f) },
+	"tags":             func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Tags(f) },
+	"keywords":         func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.Keywords(f) },
+	"list_funnel":      func(c *pirsch.Client, f *pirsch.Filter, _ url.Values) (any, error) { return c.ListFunnel(f.DomainID) },
+	"funnel": func(c *pirsch.Client, f *pirsch.Filter, query url.Values) (any, error) {
+		return c.Funnel(query.Get("funnel_id"), f)
+	},
+}
+
+// stats serves the statistics of a client.
+// Results are cached in memory, so that widgets embedded on a website don't hit the Pirsch API on every page view.
+// Concurrent requests for a result that is not cached yet wait for the first one instead of requesting it again.
+type stats struct {
+	api        *pirsch.Client
+	domainID   string
+	ttl        map[string]time.Duration
+	defaultTTL time.Duration
+	maxEntries int
+	cache      map[string]statsCacheEntry
+	calls      map[string]*statsCall
+	m          sync.Mutex
+	domain     sync.Mutex
+}
+
+type statsCacheEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// statsCall is a request to the Pirsch API in progress.
+type statsCall struct {
+	done   chan struct{}
+	data   []byte
+	status int
+}
+
+// statsCacheKey identifies a result by the parsed filter, so that unknown query parameters don't bypass the cache.
+type statsCacheKey struct {
+	Endpoint  string            `json:"endpoint"`
+	Filter    *pirsch.Filter    `json:"filter"`
+	EventMeta map[string]string `json:"event_meta"`
+	Tags      map[string]string `json:"tags"`
+	FunnelID  string            `json:"funnel_id"`
+}
+
+// serveStats sets up the statistics endpoints for the configured client.
+func serveStats(router chi.Router) {
+	s := newStats()
+
+	for _, c := range clients {
+		if c.name == config.Stats.Client {
+			s.api = c.api
+			break
+		}
+	}
+
+	router.Get(filepath.Join(config.BasePath, config.Stats.Path, "{endpoint}"), s.serve)
+}
+
+func newStats() *stats {
+	ttl := make(map[string]time.Duration, len(config.Stats.EndpointTTL))
+
+	for endpoint, seconds := range config.Stats.EndpointTTL {
+		ttl[endpoint] = time.Duration(seconds) * time.Second
+	}
+
+	return &stats{
+		ttl:        ttl,
+		defaultTTL: time.Duration(config.Stats.TTL) * time.Second,
+		maxEntries: config.Stats.MaxEntries,
+		cache:      make(map[string]statsCacheEntry),
+		calls:      make(map[string]*statsCall),
+	}
+}
+
+func (s *stats) serve(w http.ResponseWriter, r *http.Request) {
+	if !validStatsToken(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	name := chi.URLParam(r, "endpoint")
+	endpoint, found := statsEndpoints[name]
+
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := getStatsFilter("", query)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ttl := s.getTTL(name)
+	key := getStatsCacheKey(name, filter, query)
+	data := s.get(key)
+
+	if data == nil {
+		var status int
+		data, status = s.load(key, ttl, func() ([]byte, int) {
+			return s.fetch(name, endpoint, filter, query)
+		})
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// shared caches must not store responses for the Authorization header, but widgets passing the token in the URL can be cached by CDNs
+	cacheControl := "public"
+
+	if r.Header.Get("Authorization") != "" {
+		cacheControl = "private"
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", cacheControl, int(ttl.Seconds())))
+
+	if _, err := w.Write(data); err != nil {
+		slog.Error("Error sending statistics", "err", err)
+	}
+}
+
+// load calls fetch and caches the result if successful.
+// Concurrent calls for the same key wait for the first one and return its result.
+func (s *stats) load(key string, ttl time.Duration, fetch func() ([]byte, int)) ([]byte, int) {
+	s.m.Lock()
+
+	if call, found := s.calls[key]; found {
+		s.m.Unlock()
+		<-call.done
+		return call.data, call.status
+	}
+
+	call := &statsCall{done: make(chan struct{})}
+	s.calls[key] = call
+	s.m.Unlock()
+	call.data, call.status = fetch()
+
+	if call.status == http.StatusOK {
+		s.set(key, call.data, ttl)
+	}
+
+	s.m.Lock()
+	delete(s.calls, key)
+	s.m.Unlock()
+	close(call.done)
+	return call.data, call.status
+}
+
+// fetch requests the statistics from Pirsch and returns the encoded result and the response status.
+func (s *stats) fetch(name string, endpoint statsEndpoint, filter *pirsch.Filter, query url.Values) ([]byte, int) {
+	domainID, err := s.getDomainID()
+
+	if err != nil {
+		slog.Error("Error reading domain for statistics", "err", err)
+		return nil, http.StatusBadGateway
+	}
+
+	filter.DomainID = domainID
+	result, err := endpoint(s.api, filter, query)
+
+	if err != nil {
+		slog.Error("Error reading statistics", "err", err, "endpoint", name)
+		return nil, http.StatusBadGateway
+	}
+
+	data, err := json.Marshal(result)
+
+	if err != nil {
+		slog.Error("Error encoding statistics", "err", err, "endpoint", name)
+		return nil, http.StatusInternalServerError
+	}
+
+	return data, http.StatusOK
+}
+
+// getDomainID returns the domain ID of the client.
+// It's requested once and retried on the next request in case it fails.
+func (s *stats) getDomainID() (string, error) {
+	s.domain.Lock()
+	defer s.domain.Unlock()
+
+	if s.domainID != "" {
+		return s.domainID, nil
+	}
+
+	domain, err := s.api.Domain()
+
+	if err != nil {
+		return "", err
+	}
+
+	s.domainID = domain.ID
+	return s.domainID, nil
+}
+
+func (s *stats) getTTL(endpoint string) time.Duration {
+	if ttl, found := s.ttl[endpoint]; found {
+		return ttl
+	}
+
+	return s.defaultTTL
+}
+
+func (s *stats) get(key string) []byte {
+	s.m.Lock()
+	defer s.m.Unlock()
+	entry, found := s.cache[key]
+
+	if !found || entry.expiresAt.Before(time.Now()) {
+		return nil
+	}
+
+	return entry.data
+}
+
+// set caches the data.
+// If the cache is full, expired entries are removed first and the entries expiring next otherwise.
+func (s *stats) set(key string, data []byte, ttl time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, found := s.cache[key]; !found && len(s.cache) >= s.maxEntries {
+		now := time.Now()
+
+		for k, entry := range s.cache {
+			if entry.expiresAt.Before(now) {
+				delete(s.cache, k)
+			}
+		}
+
+		for len(s.cache) >= s.maxEntries {
+			var next string
+			var expiresAt time.Time
+
+			for k, entry := range s.cache {
+				if next == "" || entry.expiresAt.Before(expiresAt) {
+					next, expiresAt = k, entry.expiresAt
+				}
+			}
+
+			delete(s.cache, next)
+		}
+	}
+
+	s.cache[key] = statsCacheEntry{data: data, expiresAt: time.Now().Add(ttl)}
+}
+
+// getStatsCacheKey returns the cache key for the endpoint and filter.
+// The filter must not have the domain ID set yet.
+func getStatsCacheKey(endpoint string, filter *pirsch.Filter, query url.Values) string {
+	key := statsCacheKey{
+		Endpoint:  endpoint,
+		Filter:    filter,
+		EventMeta: filter.EventMeta,
+		Tags:      filter.Tags,
+	}
+
+	if endpoint == "funnel" {
+		key.FunnelID = query.Get("funnel_id")
+	}
+
+	// the key only consists of strings, numbers, and maps, so encoding it cannot fail
+	data, _ := json.Marshal(key)
+	return string(data)
+}
+
+// validStatsToken checks the read token passed as a bearer token or the "token" query parameter.
+func validStatsToken(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if !found {
+		token = r.URL.Query().Get("token")
+	}
+
+	if token == "" {
+		return false
+	}
+
+	for _, t := range config.Stats.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getStatsFilter creates the filter from the query parameters.
+// The parameters are the same as for the Pirsch API, except for the domain ID, which is set by the proxy.
+// The date range defaults to today.
+func getStatsFilter(domainID string, query url.Values) (*pirsch.Filter, error) {
+	today := time.Now().UTC().Format(statsDateFormat)
+	from, err := time.Parse(statsDateFormat, getQueryDefault(query, "from", today))
+
+	if err != nil {
+		return nil, errStatsDate
+	}
+
+	to, err := time.Parse(statsDateFormat, getQueryDefault(query, "to", today))
+
+	if err != nil {
+		return nil, errStatsDate
+	}
+
+	start, _ := strconv.Atoi(query.Get("start"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	includeAvgTimeOnPage, _ := strconv.ParseBool(query.Get("include_avg_time_on_page"))
+	return &pirsch.Filter{
+		DomainID:             domainID,
+		From:                 from,
+		To:                   to,
+		Start:                start,
+		Scale:                pirsch.Scale(query.Get("scale")),
+		Timezone:             query.Get("tz"),
+		Path:                 query["path"],
+		Pattern:              query["pattern"],
+		EntryPath:            query["entry_path"],
+		ExitPath:             query["exit_path"],
+		Event:                query["event"],
+		EventMetaKey:         query["event_meta_key"],
+		EventMeta:            getPrefixedParams(query, metaParamPrefix),
+		Language:             query["language"],
+		Country:              query["country"],
+		Region:               query["region"],
+		City:                 query["city"],
+		Referrer:             query["referrer"],
+		ReferrerName:         query["referrer_name"],
+		OS:                   query["os"],
+		Browser:              query["browser"],
+		Platform:             query.Get("platform"),
+		ScreenClass:          query["screen_class"],
+		UTMSource:            query["utm_source"],
+		UTMMedium:            query["utm_medium"],
+		UTMCampaign:          query["utm_campaign"],
+		UTMContent:           query["utm_content"],
+		UTMTerm:              query["utm_term"],
+		Tag:                  query["tag"],
+		Tags:                 getTags(query),
+		CustomMetricKey:      query.Get("custom_metric_key"),
+		CustomMetricType:     pirsch.CustomMetricType(query.Get("custom_metric_type")),
+		IncludeAvgTimeOnPage: includeAvgTimeOnPage,
+		Offset:               offset,
+		Limit:                limit,
+		Sort:                 query.Get("sort"),
+		Direction:            query.Get("direction"),
+		Search:               query.Get("search"),
+	}, nil
+}
+
+func getQueryDefault(query url.Values, key, defaultValue string) string {
+	if value := query.Get(key); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	pirsch "github.com/pirsch-analytics/pirsch-go-sdk/v2/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/domain":
+			_, _ = w.Write([]byte(`[{"id": "domain-id", "hostname": "example.com"}]`))
+		case "/api/v1/statistics/total":
+			requests.Add(1)
+			assert.Equal(t, "domain-id", r.URL.Query().Get("id"))
+			assert.Equal(t, "2024-01-01", r.URL.Query().Get("from"))
+			assert.Equal(t, "/blog", r.URL.Query().Get("path"))
+			_, _ = w.Write([]byte(`{"visitors": 42}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	setTestClients(t, newTestClient("blog", server))
+	config.BasePath = "/p"
+	config.Stats = Stats{Client: "blog", Path: "stats", Tokens: []string{"read-token"}, TTL: 60, MaxEntries: 10}
+	router := chi.NewRouter()
+	serveStats(router)
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/p/stats/total?from=2024-01-01&to=2024-01-31&path=/blog&token=read-token", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+		var total pirsch.TotalVisitorStats
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &total))
+		assert.Equal(t, 42, total.Visitors)
+	}
+
+	assert.Equal(t, int32(1), requests.Load())
+
+	// unknown parameters don't bypass the cache
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/p/stats/total?from=2024-01-01&to=2024-01-31&path=/blog&x=random&token=read-token", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(1), requests.Load())
+	req := httptest.NewRequest(http.MethodGet, "/p/stats/total?from=2024-01-01&path=/blog", nil)
+	req.Header.Set("Authorization", "Bearer read-token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "private, max-age=60", w.Header().Get("Cache-Control"))
+	assert.Equal(t, int32(2), requests.Load())
+
+	for path, status := range map[string]int{
+		"/p/stats/total":                                     http.StatusUnauthorized,
+		"/p/stats/total?token=invalid":                       http.StatusUnauthorized,
+		"/p/stats/unknown?token=read-token":                  http.StatusNotFound,
+		"/p/stats/total?from=yesterday&token=read-token":     http.StatusBadRequest,
+		"/p/stats/visitors?from=2024-01-01&token=read-token": http.StatusBadGateway,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, status, w.Code, path)
+	}
+}
+
+func TestStatsCache(t *testing.T) {
+	setTestClients(t)
+	config.Stats = Stats{TTL: 60, EndpointTTL: map[string]int{"active_visitors": 10}, MaxEntries: 2}
+	s := newStats()
+	assert.Equal(t, time.Second*10, s.getTTL("active_visitors"))
+	assert.Equal(t, time.Minute, s.getTTL("visitors"))
+	s.set("a", []byte("a"), time.Minute)
+	s.set("b", []byte("b"), -time.Second)
+	assert.Equal(t, []byte("a"), s.get("a"))
+	assert.Nil(t, s.get("b"))
+	s.set("c", []byte("c"), time.Hour)
+	s.set("d", []byte("d"), time.Hour)
+	assert.Len(t, s.cache, 2)
+	assert.Nil(t, s.get("a"))
+	assert.Equal(t, []byte("c"), s.get("c"))
+	assert.Equal(t, []byte("d"), s.get("d"))
+}
+
+func TestStatsLoad(t *testing.T) {
+	setTestClients(t)
+	config.Stats = Stats{TTL: 60, MaxEntries: 10}
+	s := newStats()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for range 5 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			data, status := s.load("key", time.Minute, func() ([]byte, int) {
+				calls.Add(1)
+				<-release
+				return []byte("data"), http.StatusOK
+			})
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, []byte("data"), data)
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		return calls.Load() == 1
+	}, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 10)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, []byte("data"), s.get("key"))
+	assert.Empty(t, s.calls)
+}
+
+func TestGetStatsCacheKey(t *testing.T) {
+	a, _ := getStatsFilter("", url.Values{"from": {"2024-01-01"}, "tag_author": {"John"}})
+	b, _ := getStatsFilter("", url.Values{"from": {"2024-01-01"}, "tag_author": {"Jane"}})
+	assert.NotEqual(t, getStatsCacheKey("total", a, nil), getStatsCacheKey("total", b, nil))
+	assert.NotEqual(t, getStatsCacheKey("total", a, nil), getStatsCacheKey("pages", a, nil))
+	assert.NotEqual(t, getStatsCacheKey("funnel", a, url.Values{"funnel_id": {"1"}}), getStatsCacheKey("funnel", a, url.Values{"funnel_id": {"2"}}))
+	assert.Equal(t, getStatsCacheKey("total", a, url.Values{"funnel_id": {"1"}}), getStatsCacheKey("total", a, url.Values{"funnel_id": {"2"}}))
+}
+
+func TestGetStatsFilter(t *testing.T) {
+	query, _ := url.ParseQuery("from=2024-01-01&to=2024-01-31&path=/a&path=/b&tag_author=John&meta_plan=pro&limit=5&scale=week")
+	filter, err := getStatsFilter("domain-id", query)
+	assert.NoError(t, err)
+	assert.Equal(t, "domain-id", filter.DomainID)
+	assert.Equal(t, "2024-01-01", filter.From.Format(statsDateFormat))
+	assert.Equal(t, "2024-01-31", filter.To.Format(statsDateFormat))
+	assert.Equal(t, []string{"/a", "/b"}, filter.Path)
+	assert.Equal(t, map[string]string{"author": "John"}, filter.Tags)
+	assert.Equal(t, map[string]string{"plan": "pro"}, filter.EventMeta)
+	assert.Equal(t, 5, filter.Limit)
+	assert.Equal(t, pirsch.Scale(pirsch.ScaleWeek), filter.Scale)
+	filter, err = getStatsFilter("domain-id", url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Now().UTC().Format(statsDateFormat), filter.From.Format(statsDateFormat))
+	_, err = getStatsFilter("domain-id", url.Values{"to": {fmt.Sprint(time.Now().Unix())}})
+	assert.ErrorIs(t, err, errStatsDate)
+}
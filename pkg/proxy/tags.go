@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	tagParamPrefix = "tag_"
+)
+
+// getTags returns the tags passed as "tag_<key>=value" query parameters.
+func getTags(query url.Values) map[string]string {
+	return getPrefixedParams(query, tagParamPrefix)
+}
+
+// getPrefixedParams returns all "<prefix><key>=value" parameters by key.
+func getPrefixedParams(query url.Values, prefix string) map[string]string {
+	params := make(map[string]string)
+
+	for param, values := range query {
+		if key, found := strings.CutPrefix(param, prefix); found && key != "" && len(values) > 0 {
+			params[key] = values[0]
+		}
+	}
+
+	if len(params) == 0 {
+		return nil
+	}
+
+	return params
+}
+
+// filterTags returns the tags allowed by the configuration and truncates values exceeding the maximum length.
+// All tags are allowed if the allow list is empty and the length is unlimited if the maximum length is zero.
+func filterTags(tags map[string]string, config ClientTags) map[string]string {
+	if len(tags) == 0 {
+		return tags
+	}
+
+	filtered := make(map[string]string, len(tags))
+
+	for key, value := range tags {
+		if len(config.Allowed) > 0 && !isAllowedTag(key, config.Allowed) {
+			continue
+		}
+
+		if config.MaxLength > 0 && utf8.RuneCountInString(value) > config.MaxLength {
+			value = string([]rune(value)[:config.MaxLength])
+		}
+
+		filtered[key] = value
+	}
+
+	if len(filtered) == 0 {
+		return nil
+	}
+
+	return filtered
+}
+
+func isAllowedTag(key string, allowed []string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(key, a) {
+			return true
+		}
+	}
+
+	return false
+}
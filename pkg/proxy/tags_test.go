@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTags(t *testing.T) {
+	query, _ := url.ParseQuery("url=https://example.com&tag_author=John&tag_category=Go&tag_=empty")
+	assert.Equal(t, map[string]string{"author": "John", "category": "Go"}, getTags(query))
+	query, _ = url.ParseQuery("url=https://example.com")
+	assert.Nil(t, getTags(query))
+}
+
+func TestFilterTags(t *testing.T) {
+	tags := map[string]string{"author": "John", "category": "Golang"}
+	assert.Equal(t, tags, filterTags(tags, ClientTags{}))
+	assert.Equal(t, map[string]string{"author": "John"}, filterTags(tags, ClientTags{Allowed: []string{"Author"}}))
+	assert.Equal(t, map[string]string{"author": "Jo", "category": "Go"}, filterTags(tags, ClientTags{MaxLength: 2}))
+	assert.Nil(t, filterTags(tags, ClientTags{Allowed: []string{"unknown"}}))
+	assert.Equal(t, map[string]string{"name": "äö"}, filterTags(map[string]string{"name": "äöü"}, ClientTags{MaxLength: 2}))
+}
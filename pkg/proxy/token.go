@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/pirsch-analytics/pirsch-go-proxy/pkg/token"
+)
+
+var (
+	errToken = errors.New("tracking token invalid")
+)
+
+// getToken returns the token passed with the request or the value of the token cookie otherwise.
+// The cookie is set by the backend, so that tokens can be used with scripts not passing them, like pa.js.
+func getToken(r *http.Request, value string) string {
+	if value != "" || config.TrackingToken.Cookie == "" {
+		return value
+	}
+
+	if cookie, err := r.Cookie(config.TrackingToken.Cookie); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// verifyToken checks the signed tracking token against the page URL of the hit if a secret is configured.
+func verifyToken(hit *Hit, value string) error {
+	if config.TrackingToken.Secret == "" {
+		return nil
+	}
+
+	var err error
+
+	if u := hit.PageURL(); u == nil {
+		err = token.ErrMismatch
+	} else {
+		err = token.Verify([]byte(config.TrackingToken.Secret), value, u.Hostname(), u.Path)
+	}
+
+	if err != nil {
+		slog.Debug("Hit rejected", "err", err, "kind", hit.Kind, "url", hit.Options.URL)
+		return fmt.Errorf("%w: %w", errToken, err)
+	}
+
+	return nil
+}
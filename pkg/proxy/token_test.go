@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pirsch-analytics/pirsch-go-proxy/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageViewToken(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	config.TrackingToken.Secret = "secret"
+	valid := token.New([]byte("secret"), "example.com", "/foo", time.Minute)
+
+	for value, status := range map[string]int{
+		valid: http.StatusOK,
+		"":    http.StatusForbidden,
+		token.New([]byte("secret"), "example.com", "/bar", time.Minute):  http.StatusForbidden,
+		token.New([]byte("secret"), "example.com", "/foo", -time.Minute): http.StatusForbidden,
+		token.New([]byte("other"), "example.com", "/foo", time.Minute):   http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo&token="+url.QueryEscape(value), nil)
+		w := httptest.NewRecorder()
+		pageView(w, req)
+		assert.Equal(t, status, w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/p/px?url=https://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	pixel(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, mock.received(), 1)
+}
+
+func TestEventToken(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	config.TrackingToken.Secret = "secret"
+	config.Batch = Batch{MaxItems: 10}
+	valid := token.New([]byte("secret"), "example.com", "", time.Minute)
+	req := httptest.NewRequest(http.MethodPost, "/p/e", strings.NewReader(`{"url": "https://example.com/foo", "event_name": "Signup", "token": "`+valid+`"}`))
+	w := httptest.NewRecorder()
+	event(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	req = httptest.NewRequest(http.MethodPost, "/p/e?token="+url.QueryEscape(valid), strings.NewReader(`{"url": "https://example.com/foo", "event_name": "Signup"}`))
+	w = httptest.NewRecorder()
+	event(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	req = httptest.NewRequest(http.MethodPost, "/p/e", strings.NewReader(`{"url": "https://other.com/foo", "event_name": "Signup", "token": "`+valid+`"}`))
+	w = httptest.NewRecorder()
+	event(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	req = httptest.NewRequest(http.MethodPost, "/p/b", strings.NewReader(`[
+		{"type": "page_view", "url": "https://example.com/foo", "token": "`+valid+`"},
+		{"type": "page_view", "url": "https://example.com/foo"}
+	]`))
+	w = httptest.NewRecorder()
+	batch(w, req)
+	var results []batchResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	assert.Equal(t, http.StatusOK, results[0].Status)
+	assert.Equal(t, http.StatusForbidden, results[1].Status)
+	assert.Contains(t, results[1].Error, token.ErrMissing.Error())
+	assert.Len(t, mock.received(), 3)
+}
+
+func TestSessionToken(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	config.TrackingToken.Secret = "secret"
+	valid := token.New([]byte("secret"), "example.com", "/foo", time.Minute)
+	req := httptest.NewRequest(http.MethodPost, "/p/s?url=https://example.com/foo", nil)
+	w := httptest.NewRecorder()
+	session(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	req = httptest.NewRequest(http.MethodPost, "/p/s?url=https://example.com/foo&token="+url.QueryEscape(valid), nil)
+	w = httptest.NewRecorder()
+	session(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	req = httptest.NewRequest(http.MethodPost, "/p/s", strings.NewReader(`{"url": "https://example.com/foo", "token": "`+valid+`"}`))
+	w = httptest.NewRecorder()
+	session(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, mock.received(), 2)
+}
+
+func TestRedirectToken(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	config.TrackingToken.Secret = "secret"
+	config.Redirect = Redirect{EventName: "Outbound Link"}
+	handler := redirect(NewHostnameFilter([]string{"github.com"}))
+	valid := token.New([]byte("secret"), "example.com", "/blog", time.Minute)
+
+	for _, value := range []string{"", valid} {
+		req := httptest.NewRequest(http.MethodGet, "/p/r?to=https://github.com&from=https://example.com/blog&token="+url.QueryEscape(value), nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		assert.Equal(t, http.StatusFound, w.Code)
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(mock.received()) == 1
+	}, time.Second, time.Millisecond*5)
+	time.Sleep(time.Millisecond * 20)
+	assert.Len(t, mock.received(), 1)
+}
+
+func TestTokenCookie(t *testing.T) {
+	mock, server := newAPIMock(t)
+	setTestClients(t, newTestClient("test", server))
+	config.TrackingToken = TrackingToken{Secret: "secret", Cookie: "pirsch_token"}
+	valid := token.New([]byte("secret"), "example.com", "", time.Minute)
+	req := httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo", nil)
+	req.AddCookie(&http.Cookie{Name: "pirsch_token", Value: valid})
+	w := httptest.NewRecorder()
+	pageView(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	req = httptest.NewRequest(http.MethodPost, "/p/e", strings.NewReader(`{"url": "https://example.com/foo", "event_name": "Signup"}`))
+	req.AddCookie(&http.Cookie{Name: "pirsch_token", Value: valid})
+	w = httptest.NewRecorder()
+	event(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	req = httptest.NewRequest(http.MethodPost, "/p/s?url=https://example.com/foo", nil)
+	req.AddCookie(&http.Cookie{Name: "pirsch_token", Value: valid})
+	w = httptest.NewRecorder()
+	session(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, mock.received(), 3)
+
+	// a token passed with the request takes precedence over the cookie
+	req = httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo&token=invalid", nil)
+	req.AddCookie(&http.Cookie{Name: "pirsch_token", Value: valid})
+	w = httptest.NewRecorder()
+	pageView(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	req = httptest.NewRequest(http.MethodGet, "/p/pv?url=https://example.com/foo", nil)
+	req.AddCookie(&http.Cookie{Name: "other", Value: valid})
+	w = httptest.NewRecorder()
+	pageView(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Len(t, mock.received(), 3)
+}
@@ -0,0 +1,120 @@
+// Package token creates and verifies signed tracking tokens.
+//
+// A token is issued by the backend rendering a page and is only valid for the hostname and path of that page
+// until it expires. The proxy rejects hits without a valid token if a secret is configured, so that hits can't be forged.
+//
+//	t := token.New([]byte("secret"), "example.com", "/blog/post", time.Minute*10)
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMissing is returned if no token was passed.
+	ErrMissing = errors.New("token missing")
+
+	// ErrMalformed is returned if the token cannot be decoded.
+	ErrMalformed = errors.New("token malformed")
+
+	// ErrSignature is returned if the signature doesn't match.
+	ErrSignature = errors.New("token signature invalid")
+
+	// ErrExpired is returned if the token has expired.
+	ErrExpired = errors.New("token expired")
+
+	// ErrMismatch is returned if the token was issued for a different hostname or path.
+	ErrMismatch = errors.New("token does not match URL")
+)
+
+// New returns a token for the hostname and path valid for the given duration.
+// An empty path allows all paths on the hostname.
+func New(secret []byte, hostname, path string, ttl time.Duration) string {
+	return newToken(secret, hostname, path, time.Now().Add(ttl))
+}
+
+// Verify checks the token against the hostname and path of the tracked URL.
+func Verify(secret []byte, token, hostname, path string) error {
+	return verify(secret, token, hostname, path, time.Now())
+}
+
+func newToken(secret []byte, hostname, path string, expires time.Time) string {
+	payload := strings.Join([]string{strings.ToLower(hostname), normalizePath(path), strconv.FormatInt(expires.Unix(), 10)}, "\n")
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(sign(secret, payload))
+}
+
+func verify(secret []byte, token, hostname, path string, now time.Time) error {
+	if token == "" {
+		return ErrMissing
+	}
+
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+
+	if !found {
+		return ErrMalformed
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+
+	if err != nil {
+		return ErrMalformed
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+
+	if err != nil {
+		return ErrMalformed
+	}
+
+	if !hmac.Equal(signature, sign(secret, string(payload))) {
+		return ErrSignature
+	}
+
+	parts := strings.Split(string(payload), "\n")
+
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+
+	if err != nil {
+		return ErrMalformed
+	}
+
+	if now.Unix() > expires {
+		return ErrExpired
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	if parts[0] != strings.ToLower(hostname) || (parts[1] != "" && !strings.EqualFold(parts[1], normalizePath(path))) {
+		return ErrMismatch
+	}
+
+	return nil
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// normalizePath adds the leading slash to the path if missing.
+// An empty path is kept, as it allows all paths.
+func normalizePath(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	return "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,37 @@
+package token
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	token := New(secret, "Example.com", "/blog/post", time.Minute)
+	assert.NoError(t, Verify(secret, token, "example.com", "/blog/post"))
+	assert.NoError(t, Verify(secret, token, "EXAMPLE.COM", "/Blog/Post"))
+	assert.ErrorIs(t, Verify(secret, token, "example.com", "/blog/other"), ErrMismatch)
+	assert.ErrorIs(t, Verify(secret, token, "other.com", "/blog/post"), ErrMismatch)
+	assert.ErrorIs(t, Verify([]byte("other"), token, "example.com", "/blog/post"), ErrSignature)
+	assert.ErrorIs(t, verify(secret, token, "example.com", "/blog/post", time.Now().Add(time.Minute*2)), ErrExpired)
+	assert.ErrorIs(t, Verify(secret, "", "example.com", "/blog/post"), ErrMissing)
+	assert.ErrorIs(t, Verify(secret, "invalid", "example.com", "/blog/post"), ErrMalformed)
+	assert.ErrorIs(t, Verify(secret, "!.!", "example.com", "/blog/post"), ErrMalformed)
+	payload, _, _ := strings.Cut(token, ".")
+	assert.ErrorIs(t, Verify(secret, payload+".c2lnbmF0dXJl", "example.com", "/blog/post"), ErrSignature)
+}
+
+func TestVerifyPath(t *testing.T) {
+	secret := []byte("secret")
+	token := New(secret, "example.com", "", time.Minute)
+	assert.NoError(t, Verify(secret, token, "example.com", "/any/path"))
+	assert.NoError(t, Verify(secret, token, "example.com", ""))
+	token = New(secret, "example.com", "/", time.Minute)
+	assert.NoError(t, Verify(secret, token, "example.com", ""))
+	assert.ErrorIs(t, Verify(secret, token, "example.com", "/foo"), ErrMismatch)
+	token = New(secret, "example.com", "foo", time.Minute)
+	assert.NoError(t, Verify(secret, token, "example.com", "/foo"))
+}